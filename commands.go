@@ -7,29 +7,101 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func registerBuildCommand(parser *argparse.Parser) {
+// verifyDriftExitCode is exit status of verify when installed files differ from package database,
+// errors exit with status 1.
+const verifyDriftExitCode = 2
+
+func registerBuildCommand(parser *argparse.Parser, config *gum.Config) {
 	build := parser.AddCommand("build", "build package from definition file", &argparse.ParserConfig{})
 	pkgFile := build.String("", "definition_file", &argparse.Option{Positional: true, Help: "path to package definition file"})
 	outFile := build.String("o", "out", &argparse.Option{Help: "path to output package archive"})
-	buildDir := build.String("b", "build_dir", &argparse.Option{Help: "path to build directory", Default: gum.DefaultBuildDir})
-	fakeRootDir := build.String("f", "fake_root_dir", &argparse.Option{Help: "path to fake root directory", Default: gum.DefaultFakeRootDir})
-	tempDir := build.String("t", "temp_dir", &argparse.Option{Help: "path to temp directory", Default: gum.DefaultTempDir})
-	sourcesDir := build.String("", "sources_dir", &argparse.Option{Help: "look for sources in this directory, if sound no sources will be downloaded", Default: gum.DefaultTempDir})
-	verbose := build.Flag("v", "verbose", &argparse.Option{Help: "print output from underlying processes"})
+	buildOptions := registerBuildOptions(build, config)
+	updateChecksums := build.Flag("", "update-checksums", &argparse.Option{Help: "fetch sources and write their checksums into definition file instead of building"})
+	checkReproducible := build.Flag("", "check-reproducible", &argparse.Option{Help: "build package twice and list files that differ"})
 
 	build.InvokeAction = func(bool) {
 		absPkgFile, err := filepath.Abs(*pkgFile)
 		if err != nil {
 			log.Fatal(err)
 		}
+		options := buildOptions()
+		if *updateChecksums {
+			if err := gum.UpdateChecksums(absPkgFile, options.BuildDir, options.SourcesDir); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 		pkg, err := gum.ReadDefinitionFromFile(absPkgFile)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		absOutFile := getOutFile(*outFile, pkg.Name)
+		options.OutputFile = getOutFile(*outFile, pkg.Name, options.Compression)
+		if *checkReproducible {
+			differences, err := gum.CheckReproducible(pkg, options)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, difference := range differences {
+				fmt.Println(difference)
+			}
+			if len(differences) > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+
+		err = gum.Build(pkg, options)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func registerBuildAllCommand(parser *argparse.Parser, config *gum.Config) {
+	buildAll := parser.AddCommand("build-all", "build every package definition in directory, dependencies first", &argparse.ParserConfig{})
+	dir := buildAll.String("", "definition_dir", &argparse.Option{Positional: true, Help: "directory with package definition files"})
+	outDir := buildAll.String("o", "out_dir", &argparse.Option{Help: "directory receiving package archives", Default: "."})
+	stagingDir := buildAll.String("", "staging_dir", &argparse.Option{Help: "directory built packages are installed into for later builds", Default: gum.DefaultStagingDir})
+	force := buildAll.Flag("", "force", &argparse.Option{Help: "rebuild packages with up to date archives"})
+	jobs := buildAll.Int("j", "jobs", &argparse.Option{Help: "number of packages built at the same time", Default: strconv.Itoa(config.Jobs)})
+	buildOptions := registerBuildOptions(buildAll, config)
+
+	buildAll.InvokeAction = func(bool) {
+		err := gum.BuildAll(*dir, gum.BuildAllOptions{
+			Build:      buildOptions(),
+			OutputDir:  *outDir,
+			StagingDir: *stagingDir,
+			Force:      *force,
+			Jobs:       *jobs,
+			Progress:   printBuildProgress,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// registerBuildOptions adds options shared by build commands to command. Returned function collects their
+// values once arguments are parsed.
+func registerBuildOptions(command *argparse.Parser, config *gum.Config) func() gum.BuildOptions {
+	buildDir := command.String("b", "build_dir", &argparse.Option{Help: "path to build directory", Default: config.BuildDir})
+	fakeRootDir := command.String("f", "fake_root_dir", &argparse.Option{Help: "path to fake root directory", Default: config.FakeRootDir})
+	tempDir := command.String("t", "temp_dir", &argparse.Option{Help: "path to temp directory", Default: config.TempDir})
+	sourcesDir := command.String("", "sources_dir", &argparse.Option{Help: "look for sources in this directory, if sound no sources will be downloaded", Default: config.TempDir})
+	verbose := command.Flag("v", "verbose", &argparse.Option{Help: "print output from underlying processes"})
+	compression := command.String("c", "compression", &argparse.Option{Help: "package archive compression codec", Default: config.Compression, Choices: compressionChoices()})
+	compressionLevel := command.Int("l", "compression_level", &argparse.Option{Help: "compression level, codec default if 0", Default: strconv.Itoa(config.CompressionLevel)})
+	sandbox := command.Flag("s", "sandbox", &argparse.Option{Help: "run build script in user namespace without network, writing only to build directories"})
+	signKey := command.String("k", "sign_key", &argparse.Option{Help: "sign package with Ed25519 private key from file", Default: config.SignKey})
+	reproducible := command.Flag("", "reproducible", &argparse.Option{Help: "normalize ownership and timestamps of packaged files, implied by SOURCE_DATE_EPOCH"})
+
+	return func() gum.BuildOptions {
 		absBuildDir, err := filepath.Abs(*buildDir)
 		if err != nil {
 			log.Fatal(err)
@@ -43,120 +115,369 @@ func registerBuildCommand(parser *argparse.Parser) {
 			log.Fatal(err)
 		}
 
-		err = gum.Build(pkg, absOutFile, absBuildDir, absFakeRootDir, absTempDir, *verbose, sourcesDir)
+		return gum.BuildOptions{
+			BuildDir:         absBuildDir,
+			FakeRootDir:      absFakeRootDir,
+			TempDir:          absTempDir,
+			SourcesDir:       sourcesDir,
+			Compression:      *compression,
+			CompressionLevel: *compressionLevel,
+			Verbose:          *verbose,
+			Sandbox:          *sandbox,
+			SignKey:          *signKey,
+			Reproducible:     *reproducible,
+		}
+	}
+}
+
+func registerInstallCommand(parser *argparse.Parser, root *string) {
+	install := parser.AddCommand("install", "install package from archive file or repository", &argparse.ParserConfig{})
+	pkgFile := install.String("", "package", &argparse.Option{
+		Positional: true,
+		Help:       "path to package archive file or name of package from repository, e.g. openssl>=3.0"})
+	disableIndex := install.Flag("", "disable_index", &argparse.Option{HideEntry: true})
+	allowUnsigned := install.Flag("", "allow_unsigned", &argparse.Option{Help: "install packages not signed by a trusted key"})
+	verbose := install.Flag("v", "verbose", &argparse.Option{Help: "print output from underlying processes"})
+	overwrite := repeatedString(install, "overwrite", &argparse.Option{Meta: "GLOB", Help: "replace conflicting files matching glob, can be repeated"})
+
+	install.InvokeAction = func(bool) {
+		options := gum.InstallOptions{
+			Verbose:       *verbose,
+			DisableIndex:  *disableIndex,
+			AllowUnsigned: *allowUnsigned,
+			Overwrite:     *overwrite,
+		}
+		info, err := os.Stat(*pkgFile)
+		if err != nil || info.IsDir() {
+			if !gum.IsRepositoryPackageName(*pkgFile) {
+				if err == nil {
+					err = fmt.Errorf("%s is a directory", *pkgFile)
+				}
+				log.Fatal(err)
+			}
+			if err := gum.InstallFromRepositories(*pkgFile, getRootDir(*root), options); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		absPkgFile, err := filepath.Abs(*pkgFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = gum.Install(absPkgFile, getRootDir(*root), options)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
-func registerInstallCommand(parser *argparse.Parser) {
-	install := parser.AddCommand("install", "install package from archive file", &argparse.ParserConfig{})
-	pkgFile := install.String("", "archive_file", &argparse.Option{
+func registerUpgradeCommand(parser *argparse.Parser, root *string) {
+	upgrade := parser.AddCommand("upgrade", "replace installed package with version from archive file", &argparse.ParserConfig{})
+	pkgFile := upgrade.String("", "archive_file", &argparse.Option{
 		Positional: true,
 		Help:       "path to package archive file",
 		Validate:   validateFile})
-	targetDir := install.String("", "target_dir", &argparse.Option{HideEntry: true, Default: gum.RootDir})
-	disableIndex := install.Flag("", "disable_index", &argparse.Option{HideEntry: true})
-	verbose := install.Flag("v", "verbose", &argparse.Option{Help: "print output from underlying processes"})
+	force := upgrade.Flag("", "force", &argparse.Option{Help: "allow replacing package with older version"})
+	allowUnsigned := upgrade.Flag("", "allow_unsigned", &argparse.Option{Help: "install packages not signed by a trusted key"})
+	verbose := upgrade.Flag("v", "verbose", &argparse.Option{Help: "print output from underlying processes"})
+	overwrite := repeatedString(upgrade, "overwrite", &argparse.Option{Meta: "GLOB", Help: "replace conflicting files matching glob, can be repeated"})
 
-	install.InvokeAction = func(bool) {
+	upgrade.InvokeAction = func(bool) {
 		absPkgFile, err := filepath.Abs(*pkgFile)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		err = gum.Install(absPkgFile, *targetDir, *verbose, *disableIndex)
+		err = gum.Upgrade(absPkgFile, getRootDir(*root), gum.UpgradeOptions{
+			Verbose:       *verbose,
+			AllowUnsigned: *allowUnsigned,
+			Overwrite:     *overwrite,
+			Force:         *force,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func registerVerifyArchiveCommand(parser *argparse.Parser) {
+	verify := parser.AddCommand("verify-archive", "check package archive for entries escaping target directory", &argparse.ParserConfig{})
+	pkgFile := verify.String("", "archive_file", &argparse.Option{
+		Positional: true,
+		Help:       "path to package archive file",
+		Validate:   validateFile})
+
+	verify.InvokeAction = func(bool) {
+		issues, err := gum.VerifyArchive(*pkgFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, issue := range issues {
+			fmt.Println(issue.Error())
+		}
+		if len(issues) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+func registerRepoAddCommand(parser *argparse.Parser) {
+	repoAdd := parser.AddCommand("repo-add", "write repository index of package archives in directory", &argparse.ParserConfig{})
+	dir := repoAdd.String("", "repository_dir", &argparse.Option{Positional: true, Help: "directory with package archives"})
+
+	repoAdd.InvokeAction = func(bool) {
+		count, err := gum.RepositoryAdd(*dir)
 		if err != nil {
 			log.Fatal(err)
 		}
+		fmt.Printf("indexed %d packages\n", count)
 	}
 }
 
-func registerUninstallCommand(parser *argparse.Parser) {
+func registerKeygenCommand(parser *argparse.Parser, config *gum.Config) {
+	keygen := parser.AddCommand("keygen", "generate package signing key pair", &argparse.ParserConfig{})
+	path := keygen.String("", "key_path", &argparse.Option{
+		Positional: true,
+		Help:       "path of key files without extension, private key is written to .key file and public key to .pub file"})
+
+	keygen.InvokeAction = func(bool) {
+		id, err := gum.GenerateKey(*path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("generated key %s, copy %s%s to %s to trust it\n", id, *path, gum.PublicKeyFileExtension, config.KeyringDir)
+	}
+}
+
+func registerOwnsCommand(parser *argparse.Parser, root *string) {
+	owns := parser.AddCommand("owns", "show packages owning file", &argparse.ParserConfig{})
+	filePath := owns.String("", "path", &argparse.Option{Positional: true, Help: "path of file in managed system"})
+
+	owns.InvokeAction = func(bool) {
+		owners, err := gum.Owns(*filePath, getRootDir(*root))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(owners) == 0 {
+			log.Fatalf("%s is not owned by any package", *filePath)
+		}
+		for _, owner := range owners {
+			fmt.Printf("%s is owned by %s\n", owner.Path, owner.Package)
+		}
+	}
+}
+
+func registerVerifyCommand(parser *argparse.Parser, root *string) {
+	verify := parser.AddCommand("verify", "compare installed files with package database", &argparse.ParserConfig{DisableDefaultShowHelp: true})
+	packages := verify.Strings("", "package_name", &argparse.Option{Positional: true, Help: "names of packages to verify, all when omitted"})
+
+	verify.InvokeAction = func(bool) {
+		drifts, err := gum.Verify(*packages, getRootDir(*root))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, drift := range drifts {
+			fmt.Println(drift)
+		}
+		if len(drifts) > 0 {
+			os.Exit(verifyDriftExitCode)
+		}
+	}
+}
+
+func registerUninstallCommand(parser *argparse.Parser, root *string) {
 	uninstall := parser.AddCommand("uninstall", "uninstall package", &argparse.ParserConfig{})
 	pkg := uninstall.String("", "package_name", &argparse.Option{Positional: true, Help: "package name"})
 	verbose := uninstall.Flag("v", "verbose", &argparse.Option{Help: "print output from underlying processes"})
 
 	uninstall.InvokeAction = func(bool) {
-		err := gum.Uninstall(*pkg, *verbose)
+		err := gum.Uninstall(*pkg, getRootDir(*root), *verbose)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
-func registerShowCommand(parser *argparse.Parser) {
+func registerShowCommand(parser *argparse.Parser, root *string) {
 	show := parser.AddCommand("show", "display information", &argparse.ParserConfig{})
+	output := show.String("o", "output", &argparse.Option{
+		Help:        "output format",
+		Default:     outputText,
+		Choices:     outputChoices(),
+		Inheritable: true})
+
+	registerShowInstalledCommand(show, root, output)
+	registerShowFilesCommand(show, root, output)
+	registerShowPackageCommand(show, root, output)
+	registerShowTriggersCommand(show, root, output)
+	registerShowConfigCommand(show, output)
+	registerShowLogCommand(show, output)
+}
 
-	registerShowInstalledCommand(show)
-	registerShowFilesCommand(show)
-	registerShowPackageCommand(show)
-	registerShowTriggersCommand(show)
-	registerShowConfigCommand(show)
+func registerShowLogCommand(parser *argparse.Parser, output *string) {
+	logs := parser.AddCommand("log", "show output of package scripts", &argparse.ParserConfig{})
+	pkgName := logs.String("", "package_name", &argparse.Option{Positional: true, Help: "package name"})
+	stage := logs.String("s", "stage", &argparse.Option{Help: "show log of this stage only", Choices: stageChoices()})
+
+	logs.InvokeAction = func(bool) {
+		packageLogs, err := gum.PackageLogs(requirePackageName(*pkgName), *stage)
+		if err != nil {
+			log.Fatal(err)
+		}
+		render(*output, packageLogs, func() {
+			for _, packageLog := range packageLogs {
+				fmt.Printf("==> %s (%s) <==\n", packageLog.Stage, packageLog.Modified.Format(time.RFC3339))
+				fmt.Print(packageLog.Content)
+			}
+		})
+	}
 }
 
-func registerShowConfigCommand(parser *argparse.Parser) {
+func registerShowConfigCommand(parser *argparse.Parser, output *string) {
 	installed := parser.AddCommand("config", "show gumshield config", &argparse.ParserConfig{DisableDefaultShowHelp: true})
 
 	installed.InvokeAction = func(bool) {
-		err := gum.ShowConfig()
+		values, err := gum.EffectiveConfig()
 		if err != nil {
 			log.Fatal(err)
 		}
+		render(*output, values, func() {
+			for _, value := range values {
+				fmt.Printf("%s: %s (%s)\n", value.Key, value.Value, value.Source)
+			}
+		})
 	}
 }
 
-func registerShowTriggersCommand(parser *argparse.Parser) {
+func registerShowTriggersCommand(parser *argparse.Parser, root, output *string) {
 	pkg := parser.AddCommand("triggers", "show package triggers", &argparse.ParserConfig{})
 	pkgName := pkg.String("", "package_name", &argparse.Option{Positional: true, Help: "package name"})
+	archive := pkg.String("a", "archive", &argparse.Option{Help: "read package archive file instead of installed package", Validate: validateFile})
 
 	pkg.InvokeAction = func(bool) {
-		err := gum.ShowTriggers(*pkgName)
+		var triggers *gum.PackageTriggers
+		var err error
+		if *archive != "" {
+			triggers, err = gum.ArchivePackageTriggers(*archive)
+		} else {
+			triggers, err = gum.InstalledPackageTriggers(requirePackageName(*pkgName), getRootDir(*root))
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
+		render(*output, triggers, func() {
+			printScript("build", triggers.Build)
+			printScript("before install", triggers.BeforeInstall)
+			printScript("after install", triggers.AfterInstall)
+			printScript("uninstall", triggers.Uninstall)
+			if triggers.BeforeUpgrade != "" {
+				printScript("before upgrade", triggers.BeforeUpgrade)
+			}
+			if triggers.AfterUpgrade != "" {
+				printScript("after upgrade", triggers.AfterUpgrade)
+			}
+		})
 	}
 }
 
-func registerShowPackageCommand(parser *argparse.Parser) {
+func registerShowPackageCommand(parser *argparse.Parser, root, output *string) {
 	pkg := parser.AddCommand("package", "show package information", &argparse.ParserConfig{})
 	pkgName := pkg.String("", "package_name", &argparse.Option{Positional: true, Help: "package name"})
+	archive := pkg.String("a", "archive", &argparse.Option{Help: "read package archive file instead of installed package", Validate: validateFile})
 
 	pkg.InvokeAction = func(bool) {
-		err := gum.ShowPackage(*pkgName)
+		var details *gum.PackageDetails
+		var err error
+		if *archive != "" {
+			details, err = gum.ArchivePackageDetails(*archive)
+		} else {
+			details, err = gum.InstalledPackageDetails(requirePackageName(*pkgName), getRootDir(*root))
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
+		render(*output, details, func() {
+			fmt.Println("name:", details.Name)
+			fmt.Println("version:", details.Version)
+			fmt.Println("description:", details.Description)
+			fmt.Println("depends:", strings.Join(details.Depends, ", "))
+			if details.InstallTime != nil {
+				fmt.Println("install time:", details.InstallTime.Local().Format(time.RFC3339))
+				fmt.Println("install reason:", details.Reason)
+			}
+			fmt.Println("files:")
+			for _, file := range details.Files {
+				fmt.Println(file)
+			}
+		})
 	}
 }
 
-func registerShowFilesCommand(parser *argparse.Parser) {
+func registerShowFilesCommand(parser *argparse.Parser, root, output *string) {
 	files := parser.AddCommand("files", "show package files", &argparse.ParserConfig{})
 	pkgName := files.String("", "package_name", &argparse.Option{Positional: true, Help: "package name"})
+	archive := files.String("a", "archive", &argparse.Option{Help: "read package archive file instead of installed package", Validate: validateFile})
 
 	files.InvokeAction = func(bool) {
-		err := gum.ShowFiles(*pkgName)
+		var records []gum.FileRecord
+		var err error
+		if *archive != "" {
+			records, err = gum.ArchivePackageFiles(*archive)
+		} else {
+			records, err = gum.InstalledPackageFiles(requirePackageName(*pkgName), getRootDir(*root))
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
+		render(*output, records, func() {
+			for _, record := range records {
+				if *archive == "" {
+					fmt.Println(record.Path)
+					continue
+				}
+				printFileRecord(record)
+			}
+		})
 	}
 }
 
-func registerShowInstalledCommand(parser *argparse.Parser) {
+func registerShowInstalledCommand(parser *argparse.Parser, root, output *string) {
 	installed := parser.AddCommand("installed", "show installed packages", &argparse.ParserConfig{DisableDefaultShowHelp: true})
 
 	installed.InvokeAction = func(bool) {
-		err := gum.ShowInstalled()
+		packages, err := gum.ListInstalled(getRootDir(*root))
 		if err != nil {
 			log.Fatal(err)
 		}
+		render(*output, packages, func() {
+			for _, pkg := range packages {
+				fmt.Println(pkg.Name)
+			}
+		})
+	}
+}
+
+// requirePackageName exits when package name was not given.
+func requirePackageName(name string) string {
+	if name == "" {
+		log.Fatal("package name or --archive is required")
+	}
+	return name
+}
+
+func getRootDir(root string) string {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		log.Fatal(err)
 	}
+	return absRoot
 }
 
-func getOutFile(input, pkgName string) string {
+func getOutFile(input, pkgName, compression string) string {
 	if input == "" {
-		input = pkgName + gum.ArchiveFileExtension
+		input = pkgName + gum.ArchiveFileExtension + gum.CompressionExtension(compression)
 	}
 
 	absFile, err := filepath.Abs(input)
@@ -166,6 +487,45 @@ func getOutFile(input, pkgName string) string {
 	return absFile
 }
 
+func printBuildProgress(progress gum.BuildProgress) {
+	switch progress.Status {
+	case gum.BuildStatusFailed:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", progress.Name, progress.Err)
+	case gum.BuildStatusUpToDate:
+		fmt.Printf("%s %s is up to date\n", progress.Name, progress.Version)
+	default:
+		fmt.Printf("%s %s %s\n", progress.Status, progress.Name, progress.Version)
+	}
+}
+
+// repeatedString registers option taking a single value that can be given any number of times, so it does not
+// consume positional arguments following it.
+func repeatedString(parser *argparse.Parser, name string, opts *argparse.Option) *[]string {
+	values := make([]string, 0)
+	opts.Action = func(args []string) error {
+		values = append(values, args...)
+		return nil
+	}
+	parser.String("", name, opts)
+	return &values
+}
+
+func stageChoices() []interface{} {
+	choices := make([]interface{}, 0, len(gum.LogStages))
+	for _, stage := range gum.LogStages {
+		choices = append(choices, stage)
+	}
+	return choices
+}
+
+func compressionChoices() []interface{} {
+	choices := make([]interface{}, 0, len(gum.CompressionCodecs))
+	for _, codec := range gum.CompressionCodecs {
+		choices = append(choices, codec)
+	}
+	return choices
+}
+
 func validateFile(path string) error {
 	stat, err := os.Stat(path)
 	if err != nil {
@@ -4,5 +4,9 @@ go 1.18
 
 require (
 	github.com/hellflame/argparse v1.8.0
+	github.com/klauspost/compress v1.16.7
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/crypto v0.6.0
+	golang.org/x/sys v0.5.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -2,35 +2,82 @@ package gum
 
 import (
 	"archive/tar"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/sys/unix"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"syscall"
+	"time"
 )
 
-func createPackageArchive(fromDir, tempDir, outFile string, pkg *PackageDefinition) error {
+// fileID identifies file on disk regardless of the path it is reached by.
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+// getFileID returns identity of file with more than one hard link.
+func getFileID(info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileID{}, false
+	}
+
+	return fileID{device: uint64(stat.Dev), inode: stat.Ino}, true
+}
+
+// archiveOptions controls how package archive is written.
+type archiveOptions struct {
+	compression string
+	level       int
+	// signKey signs archive when set
+	signKey ed25519.PrivateKey
+	// sourceDate makes archive reproducible when set, timestamps of entries are clamped to it
+	sourceDate *time.Time
+}
+
+// createPackageArchive packs files from directory together with package definition into package archive
+// compressed with codec.
+// Ownership of packaged files is adjusted according to ownership table. Archive is signed when key is given.
+func createPackageArchive(fromDir, tempDir, outFile string, pkg *PackageDefinition, ownership ownershipTable, options archiveOptions) error {
 	files, err := listFiles(fromDir)
 	if err != nil {
 		return err
 	}
 	filesArchivePath := filepath.Join(tempDir, FilesArchiveFileName)
 
-	currentDir, err := os.Getwd()
-	if err != nil {
-		return err
-	}
-	err = os.Chdir(fromDir)
-	if err != nil {
-		return err
+	adjustFile, adjustPackage := ownership.apply, func(*tar.Header) error { return nil }
+	if options.sourceDate != nil {
+		sourceDate := *options.sourceDate
+		adjustFile = func(header *tar.Header) error {
+			header.Uname, header.Gname = "", ""
+			if err := ownership.apply(header); err != nil {
+				return err
+			}
+			normalizeHeader(header, sourceDate)
+			return nil
+		}
+		adjustPackage = func(header *tar.Header) error {
+			header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, rootUserName, rootUserName
+			normalizeHeader(header, sourceDate)
+			return nil
+		}
 	}
-	if err := createTarball(filesArchivePath, files); err != nil {
+	if err := createTarball(filesArchivePath, fromDir, files, CompressionNone, DefaultCompressionLevel, adjustFile); err != nil {
 		return err
 	}
-	err = os.Chdir(currentDir)
+
+	entries, err := describeFilesArchive(filesArchivePath)
 	if err != nil {
 		return err
 	}
-
 	pkg.Files = files
+	pkg.FileEntries = entries
 	definitionPath := filepath.Join(tempDir, DefinitionFileName)
 	if err := writeDefinition(definitionPath, pkg); err != nil {
 		return err
@@ -40,72 +87,108 @@ func createPackageArchive(fromDir, tempDir, outFile string, pkg *PackageDefiniti
 		FilesArchiveFileName,
 		DefinitionFileName,
 	}
+	if options.signKey != nil {
+		if err := signPackage(tempDir, options.signKey); err != nil {
+			return err
+		}
+		outFileFiles = append(outFileFiles, SignatureFileName)
+	}
+
+	return createTarball(outFile, tempDir, outFileFiles, options.compression, options.level, adjustPackage)
+}
 
-	currentDir, err = os.Getwd()
+// createTarball archives files given relative to dir, passing header of every entry to adjust before it is written,
+// if given.
+func createTarball(outFile, dir string, files []string, codec string, level int, adjust func(*tar.Header) error) error {
+	file, err := os.Create(outFile)
 	if err != nil {
 		return err
 	}
-	err = os.Chdir(tempDir)
+	defer file.Close()
+
+	compressor, err := newCompressingWriter(file, codec, level)
 	if err != nil {
 		return err
 	}
-	if err := createTarball(outFile, outFileFiles); err != nil {
+	defer compressor.Close()
+
+	tarWriter := tar.NewWriter(compressor)
+	defer tarWriter.Close()
+
+	hardLinks := make(map[fileID]string)
+	for _, filePath := range files {
+		err := addFileToTarWriter(dir, filePath, tarWriter, hardLinks, adjust)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
 		return err
 	}
-	err = os.Chdir(currentDir)
-	if err != nil {
+	if err := compressor.Close(); err != nil {
 		return err
 	}
 
-	return nil
+	return file.Close()
 }
 
-func createTarball(outFile string, files []string) error {
-	file, err := os.Create(outFile)
+// addFileToTarWriter writes file to archive without following symlinks. Files with more than one link
+// are stored once, later paths of the same file are written as hard links to the first one.
+// Entry is named after path relative to dir.
+func addFileToTarWriter(dir, name string, writer *tar.Writer, hardLinks map[fileID]string, adjust func(*tar.Header) error) error {
+	path := filepath.Join(dir, name)
+	stat, err := os.Lstat(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	tarWriter := tar.NewWriter(file)
-	defer tarWriter.Close()
 
-	for _, filePath := range files {
-		err := addFileToTarWriter(filePath, tarWriter)
+	linkTarget := ""
+	if stat.Mode()&os.ModeSymlink != 0 {
+		linkTarget, err = os.Readlink(path)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
-
-func addFileToTarWriter(path string, writer *tar.Writer) error {
-	file, err := os.Open(path)
+	header, err := tar.FileInfoHeader(stat, linkTarget)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	stat, err := file.Stat()
-	if err != nil {
+	header.Name = name
+	if err := recordXattrs(path, header); err != nil {
 		return err
 	}
+	if adjust != nil {
+		if err := adjust(header); err != nil {
+			return err
+		}
+	}
 
-	header, err := tar.FileInfoHeader(stat, path)
-	if err != nil {
-		return err
+	if id, ok := getFileID(stat); ok && stat.Mode().IsRegular() {
+		if firstPath, seen := hardLinks[id]; seen {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = firstPath
+			header.Size = 0
+			return writer.WriteHeader(header)
+		}
+		hardLinks[id] = name
 	}
 
-	header.Name = path
 	err = writer.WriteHeader(header)
 	if err != nil {
 		return err
 	}
-	if stat.IsDir() {
+	if !stat.Mode().IsRegular() {
 		return nil
 	}
 
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
 	_, err = io.Copy(writer, file)
 	if err != nil {
 		return err
@@ -120,20 +203,206 @@ func extractPackageArchive(archivePath, outputDir string) error {
 		return err
 	}
 
-	if err := extractTar(outputDir, file); err != nil {
+	defer file.Close()
+
+	reader, err := newDecompressingReader(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := extractTar(filepath.Base(archivePath), outputDir, reader, nil); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func extractTar(dst string, reader io.Reader) error {
+// readArchiveDefinition reads package definition from package archive without extracting it.
+// Returns parsed definition and its content.
+func readArchiveDefinition(archivePath string) (*PackageDefinition, string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	reader, err := newDecompressingReader(file)
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+
 	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		switch {
+		case err == io.EOF:
+			return nil, "", errors.New("missing " + DefinitionFileName)
+		case err != nil:
+			return nil, "", err
+		}
+		if header.Name != DefinitionFileName || header.Typeflag != tar.TypeReg {
+			continue
+		}
 
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, "", err
+		}
+		pkg, err := ParsePackageDefinition(string(content))
+		if err != nil {
+			return nil, "", err
+		}
+		return pkg, string(content), nil
+	}
+}
+
+// inspectPackageArchive streams package archive, returning package definition and headers of entries
+// of its files archive. Nothing is written to disk.
+func inspectPackageArchive(archivePath string) (*PackageDefinition, []*tar.Header, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader, err := newDecompressingReader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	var pkg *PackageDefinition
+	var headers []*tar.Header
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch path.Clean(header.Name) {
+		case DefinitionFileName:
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, nil, err
+			}
+			if pkg, err = ParsePackageDefinition(string(content)); err != nil {
+				return nil, nil, err
+			}
+		case FilesArchiveFileName:
+			headers = make([]*tar.Header, 0)
+			filesReader := tar.NewReader(tarReader)
+			for {
+				header, err := filesReader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, nil, err
+				}
+				headers = append(headers, header)
+			}
+		}
+	}
+
+	if pkg == nil {
+		return nil, nil, errors.New("missing " + DefinitionFileName)
+	}
+	if headers == nil {
+		return nil, nil, errors.New("missing " + FilesArchiveFileName)
+	}
+
+	return pkg, headers, nil
+}
+
+// describeFilesArchive returns mode of every entry of files archive at path, together with size
+// and SHA-256 checksum of regular files. Hard links get checksum of the file they link to.
+func describeFilesArchive(path string) ([]PackageFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]PackageFile, 0)
+	checksums := make(map[string]PackageFile)
+	tarReader := tar.NewReader(file)
 	for {
 		header, err := tarReader.Next()
 		switch {
 		case err == io.EOF:
+			return entries, nil
+		case err != nil:
+			return nil, err
+		}
+
+		entry := PackageFile{Path: header.Name, Mode: header.Mode & 07777}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			hash := sha256.New()
+			if _, err := io.Copy(hash, tarReader); err != nil {
+				return nil, err
+			}
+			entry.Size, entry.Sha256 = header.Size, hex.EncodeToString(hash.Sum(nil))
+			checksums[header.Name] = entry
+		case tar.TypeLink:
+			target := checksums[header.Linkname]
+			entry.Size, entry.Sha256 = target.Size, target.Sha256
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// readTarHeaders returns headers of every entry of tar archive at path.
+func readTarHeaders(path string) ([]*tar.Header, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	headers := make([]*tar.Header, 0)
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		switch {
+		case err == io.EOF:
+			return headers, nil
+		case err != nil:
+			return nil, err
+		}
+
+		headers = append(headers, header)
+	}
+}
+
+// extractTar unpacks tar stream into dst, restoring metadata recorded in archive. When transaction is given,
+// every change is recorded in it. Directories that already exist keep their metadata. Entries that could
+// be written outside of dst are rejected and symlinks already present in dst are followed only within it.
+func extractTar(archiveName, dst string, reader io.Reader, tx *transaction) error {
+	tarReader := tar.NewReader(reader)
+	createdDirs := make(map[string]*tar.Header)
+	validator := newEntryValidator(archiveName)
+	ids := loadIDMap(dst)
+
+	for {
+		header, err := tarReader.Next()
+		switch {
+		case err == io.EOF:
+			// directory times are restored last, as extracting their content modifies them
+			for path, header := range createdDirs {
+				if err := restoreMetadata(path, header, ids); err != nil {
+					return err
+				}
+			}
 			return nil
 		case err != nil:
 			return err
@@ -142,31 +411,98 @@ func extractTar(dst string, reader io.Reader) error {
 		if header == nil {
 			continue
 		}
+		if issue := validator.validate(header); issue != nil {
+			return issue
+		}
 
-		targetPath := filepath.Join(dst, header.Name)
+		resolve := resolveInRoot
+		if header.Typeflag == tar.TypeDir {
+			resolve = resolveDirInRoot
+		}
+		targetPath, err := resolve(dst, header.Name)
+		if err != nil {
+			return err
+		}
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if _, err := os.Stat(targetPath); err != nil {
-				if err := os.MkdirAll(targetPath, 0755); err != nil {
-					return err
-				}
+			if _, err := os.Stat(targetPath); err == nil {
+				continue
+			}
+			if tx != nil {
+				err = tx.mkdirAll(targetPath, 0755)
+			} else {
+				err = os.MkdirAll(targetPath, 0755)
 			}
-		case tar.TypeReg:
-			f, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
 			if err != nil {
 				return err
 			}
-			if _, err := io.Copy(f, tarReader); err != nil {
-				return err
+			createdDirs[targetPath] = header
+		case tar.TypeReg, tar.TypeSymlink, tar.TypeLink, tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+			write := func(path string) error {
+				if err := writeTarEntry(path, dst, header, tarReader); err != nil {
+					return err
+				}
+				return restoreMetadata(path, header, ids)
 			}
-
-			if err := f.Close(); err != nil {
+			if tx != nil {
+				err = tx.create(targetPath, write)
+			} else {
+				err = replaceFile(targetPath, write)
+			}
+			if err != nil {
 				return err
 			}
 		}
 	}
 }
 
+// writeTarEntry creates non directory entry of archive at path.
+func writeTarEntry(path, dst string, header *tar.Header, reader io.Reader) error {
+	mode := os.FileMode(header.Mode).Perm()
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		return os.Symlink(header.Linkname, path)
+	case tar.TypeLink:
+		linkTarget, err := resolveInRoot(dst, header.Linkname)
+		if err != nil {
+			return err
+		}
+		return os.Link(linkTarget, path)
+	case tar.TypeFifo:
+		return unix.Mkfifo(path, uint32(mode))
+	case tar.TypeChar:
+		return makeDevice(path, unix.S_IFCHR|uint32(mode), header.Devmajor, header.Devminor)
+	case tar.TypeBlock:
+		return makeDevice(path, unix.S_IFBLK|uint32(mode), header.Devmajor, header.Devminor)
+	}
+
+	return writeRegularFile(path, reader, mode)
+}
+
+// replaceFile removes file at path, so write never follows a symlink left there, and writes new one.
+func replaceFile(path string, write func(path string) error) error {
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return write(path)
+}
+
+func writeRegularFile(path string, reader io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, reader); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
 func writeDefinition(path string, pkg *PackageDefinition) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -1,54 +1,160 @@
 package gum
 
 import (
+	"crypto/ed25519"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
-func Build(pkg *PackageDefinition, outputFile, buildDir, fakeRootDir, tempDir string, verbose bool, sourcesDir *string) error {
-	absBuildDir, err := filepath.Abs(buildDir)
+// BuildOptions controls where and how package is built.
+type BuildOptions struct {
+	OutputFile       string
+	BuildDir         string
+	FakeRootDir      string
+	TempDir          string
+	SourcesDir       *string
+	Compression      string
+	CompressionLevel int
+	Verbose          bool
+	Sandbox          bool
+	SignKey          string
+	// StagingDir is passed to build script, when set
+	StagingDir string
+	// Reproducible normalizes ownership and timestamps of archive entries, also enabled by SOURCE_DATE_EPOCH
+	Reproducible bool
+}
+
+// Build builds package in work directories named after it inside configured directories, so builds of
+// different packages can run at the same time. Work directories are removed once archive is written.
+func Build(pkg *PackageDefinition, options BuildOptions) error {
+	absBuildDir, err := filepath.Abs(options.BuildDir)
 	if err != nil {
 		return err
 	}
-	absFakeRootDir, err := filepath.Abs(fakeRootDir)
+	absFakeRootDir, err := filepath.Abs(options.FakeRootDir)
 	if err != nil {
 		return err
 	}
-	absTempDir, err := filepath.Abs(tempDir)
+	absTempDir, err := filepath.Abs(options.TempDir)
 	if err != nil {
 		return err
 	}
-	absOutputFile, err := filepath.Abs(outputFile)
+	absOutputFile, err := filepath.Abs(options.OutputFile)
 	if err != nil {
 		return err
 	}
+	var signKey ed25519.PrivateKey
+	if options.SignKey != "" {
+		if signKey, err = readPrivateKey(options.SignKey); err != nil {
+			return err
+		}
+	}
+
+	dirs, err := newWorkDirs(absBuildDir, absFakeRootDir, absTempDir, pkg.Name)
+	if err != nil {
+		return err
+	}
+	defer dirs.remove()
+
+	ownershipFile := filepath.Join(dirs.temp, ownershipFileName)
+	env := append(os.Environ(),
+		BuildDirEnvVarName+"="+dirs.build,
+		FakeRootDirEnvVarName+"="+dirs.fakeRoot,
+		OwnershipFileEnvVarName+"="+ownershipFile)
+	if options.StagingDir != "" {
+		env = append(env, StagingDirEnvVarName+"="+options.StagingDir)
+	}
+	archive := archiveOptions{compression: options.Compression, level: options.CompressionLevel, signKey: signKey}
+	if _, set := os.LookupEnv(SourceDateEpochEnvVarName); set || options.Reproducible {
+		date, err := sourceDate()
+		if err != nil {
+			return err
+		}
+		archive.sourceDate = &date
+		env = append(env, SourceDateEpochEnvVarName+"="+strconv.FormatInt(date.Unix(), 10))
+	}
 
-	if err := SetEnvVars(absBuildDir, absFakeRootDir); err != nil {
+	if err := getSourcesFromLocalDir(options.SourcesDir, dirs.build, pkg.Sources); err != nil {
 		return err
 	}
-	if err := prepareDirs(absBuildDir, absFakeRootDir, absTempDir); err != nil {
+	if err := downloadSources(pkg.Sources, dirs.build); err != nil {
 		return err
 	}
-	if err := getSourcesFromLocalDir(sourcesDir, absBuildDir, pkg.Sources); err != nil {
+
+	logic := ownershipRecordingPrelude + pkg.BuildLogic
+	output := scriptOutput{pkg: pkg.Name, stage: StageBuild, verbose: options.Verbose}
+	if options.Sandbox {
+		err = runScriptInSandbox(dirs.build, logic, env, output, dirs.all())
+	} else {
+		err = runScriptInDir(dirs.build, logic, env, output)
+	}
+	if err != nil {
 		return err
 	}
-	if err := downloadSources(pkg.Sources, absBuildDir); err != nil {
+	ownership, err := newOwnershipTable(ownershipFile, dirs.fakeRoot, pkg.Permissions)
+	if err != nil {
 		return err
 	}
-	if err := runScriptInDir(absBuildDir, pkg.BuildLogic, verbose); err != nil {
+	if err := createPackageArchive(dirs.fakeRoot, dirs.temp, absOutputFile, pkg, ownership, archive); err != nil {
 		return err
 	}
-	if err := createPackageArchive(absFakeRootDir, absTempDir, absOutputFile, pkg); err != nil {
+
+	return dirs.remove()
+}
+
+// UpdateChecksums fetches sources of definition file and writes their digests into its META section, leaving
+// the rest of the file unchanged.
+func UpdateChecksums(definitionPath, buildDir string, sourcesDir *string) error {
+	content, err := os.ReadFile(definitionPath)
+	if err != nil {
 		return err
 	}
-	if err := cleanUpDirs(absBuildDir, absFakeRootDir, absTempDir); err != nil {
+	stat, err := os.Stat(definitionPath)
+	if err != nil {
+		return err
+	}
+	pkg, err := ParsePackageDefinition(string(content))
+	if err != nil {
+		return err
+	}
+	absBuildDir, err := filepath.Abs(buildDir)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	// sources are fetched into own directory, so builds sharing the build directory are left alone
+	workDir, err := makeWorkDir(absBuildDir, pkg.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	unverifiedSources := make([]Source, 0, len(pkg.Sources))
+	for _, source := range pkg.Sources {
+		unverifiedSources = append(unverifiedSources, Source{Url: source.Url})
+	}
+	if err := getSourcesFromLocalDir(sourcesDir, workDir, unverifiedSources); err != nil {
+		return err
+	}
+	if err := downloadSources(unverifiedSources, workDir); err != nil {
+		return err
+	}
+	for i := range pkg.Sources {
+		if err := pkg.Sources[i].updateChecksums(filepath.Join(workDir, pkg.Sources[i].FileName())); err != nil {
+			return err
+		}
+	}
+
+	updated, err := updateSourceChecksums(string(content), pkg.Sources)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(definitionPath, []byte(updated), stat.Mode().Perm())
 }
 
-func getSourcesFromLocalDir(dir *string, outDir string, sources []string) error {
+func getSourcesFromLocalDir(dir *string, outDir string, sources []Source) error {
 	if dir == nil {
 		return nil
 	}
@@ -58,7 +164,7 @@ func getSourcesFromLocalDir(dir *string, outDir string, sources []string) error
 	}
 
 	for _, source := range sources {
-		_, fileName := filepath.Split(source)
+		fileName := source.FileName()
 		sourceFile := filepath.Join(absSourcesDir, fileName)
 
 		if _, err := os.Stat(sourceFile); err != nil {
@@ -69,6 +175,9 @@ func getSourcesFromLocalDir(dir *string, outDir string, sources []string) error
 		if err := copyFile(sourceFile, destinationFile); err != nil {
 			return err
 		}
+		if err := source.verifyChecksums(destinationFile); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,346 @@
+package gum
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	BuildStatusBuilding = "building"
+	BuildStatusBuilt    = "built"
+	BuildStatusUpToDate = "up to date"
+	BuildStatusFailed   = "failed"
+)
+
+// BuildProgress reports change of state of package during batch build.
+type BuildProgress struct {
+	Name    string
+	Version string
+	Status  string
+	// Err is cause of failure of package, set with BuildStatusFailed
+	Err error
+}
+
+// BuildAllOptions controls batch build of every package definition in a directory.
+type BuildAllOptions struct {
+	// Build is used for every package, its OutputFile is ignored
+	Build BuildOptions
+	// OutputDir receives package archives, archives already there are reused when up to date
+	OutputDir string
+	// StagingDir is recreated and every package is installed into it after it is built
+	StagingDir string
+	// Force rebuilds packages with up to date archives
+	Force bool
+	// Jobs limits number of packages built at the same time
+	Jobs int
+	// Progress is called, one call at a time, when package starts building, is built or is up to date, and
+	// when another package fails after the build already failed, as only the first failure is returned
+	Progress func(BuildProgress)
+}
+
+// recipeResult reports finished build of recipe to scheduler.
+type recipeResult struct {
+	recipe *recipe
+	err    error
+}
+
+// recipe is package definition taking part in batch build.
+type recipe struct {
+	path    string
+	pkg     *PackageDefinition
+	archive string
+	// depends lists names of recipes that have to be built first
+	depends []string
+}
+
+// BuildAll builds every package definition found in dir, dependencies first. Up to Jobs packages whose
+// dependencies are already built are built at the same time. Each package is installed into staging directory,
+// so later builds can use it. Packages with archive newer than their definition and archives of their
+// dependencies are not rebuilt.
+func BuildAll(dir string, options BuildAllOptions) error {
+	if options.Jobs < 1 {
+		return fmt.Errorf("invalid number of jobs %d", options.Jobs)
+	}
+	absOutputDir, err := filepath.Abs(options.OutputDir)
+	if err != nil {
+		return err
+	}
+	absStagingDir, err := filepath.Abs(options.StagingDir)
+	if err != nil {
+		return err
+	}
+	recipes, err := readRecipes(dir, absOutputDir, options.Build.Compression)
+	if err != nil {
+		return err
+	}
+	order, err := buildOrder(recipes)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(absOutputDir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(absStagingDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(absStagingDir, os.ModePerm); err != nil {
+		return err
+	}
+	options.Build.StagingDir = absStagingDir
+	progressLock := sync.Mutex{}
+	report := func(r *recipe, status string, err error) {
+		if options.Progress == nil {
+			return
+		}
+		progressLock.Lock()
+		defer progressLock.Unlock()
+		options.Progress(BuildProgress{Name: r.pkg.Name, Version: r.pkg.Version, Status: status, Err: err})
+	}
+
+	results := make(chan recipeResult)
+	started := make(map[string]bool)
+	done := make(map[string]bool)
+	running := 0
+	var failure error
+	for len(done) < len(order) {
+		for _, r := range order {
+			if failure != nil || running >= options.Jobs {
+				break
+			}
+			if started[r.pkg.Name] || !r.ready(done) {
+				continue
+			}
+			started[r.pkg.Name] = true
+			running++
+			go func(r *recipe) {
+				results <- recipeResult{recipe: r, err: buildRecipe(r, recipes, options, report)}
+			}(r)
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		name := result.recipe.pkg.Name
+		err := result.err
+		if err == nil {
+			// staging database is not safe for concurrent use, so packages are staged one by one
+			if err = stagePackage(result.recipe.archive, absStagingDir, options.Build.Verbose); err != nil {
+				err = fmt.Errorf("staging: %w", err)
+			}
+		}
+		if err != nil {
+			if failure == nil {
+				failure = fmt.Errorf("%s: %w", name, err)
+			} else {
+				report(result.recipe, BuildStatusFailed, err)
+			}
+			continue
+		}
+		done[name] = true
+	}
+
+	return failure
+}
+
+// buildRecipe builds package of recipe unless its archive is up to date, reporting progress.
+func buildRecipe(r *recipe, recipes map[string]*recipe, options BuildAllOptions, report func(*recipe, string, error)) error {
+	upToDate, err := r.upToDate(recipes)
+	if err != nil {
+		return err
+	}
+	if upToDate && !options.Force {
+		report(r, BuildStatusUpToDate, nil)
+		return nil
+	}
+
+	report(r, BuildStatusBuilding, nil)
+	buildOptions := options.Build
+	buildOptions.OutputFile = r.archive
+	if err := Build(r.pkg, buildOptions); err != nil {
+		return err
+	}
+	report(r, BuildStatusBuilt, nil)
+
+	return nil
+}
+
+// ready checks if every dependency of recipe is built.
+func (r *recipe) ready(done map[string]bool) bool {
+	for _, dependency := range r.depends {
+		if !done[dependency] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readRecipes parses every package definition in dir and its subdirectories. Dependencies are limited to
+// packages defined in dir, others have to be provided by the build host.
+func readRecipes(dir, outputDir, compression string) (map[string]*recipe, error) {
+	recipes := make(map[string]*recipe)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != DefinitionFileExtension {
+			return nil
+		}
+
+		pkg, err := ReadDefinitionFromFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if pkg.Name == "" {
+			return fmt.Errorf("%s: missing package name", path)
+		}
+		if other, ok := recipes[pkg.Name]; ok {
+			return fmt.Errorf("package %s is defined by both %s and %s", pkg.Name, other.path, path)
+		}
+		recipes[pkg.Name] = &recipe{
+			path:    path,
+			pkg:     pkg,
+			archive: filepath.Join(outputDir, pkg.Name+ArchiveFileExtension+CompressionExtension(compression)),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range recipes {
+		dependencies, err := parseDependencies(append(append([]string{}, r.pkg.BuildDepends...), r.pkg.Depends...))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.path, err)
+		}
+		for _, dependency := range dependencies {
+			dependencyRecipe, ok := recipes[dependency.Name]
+			if !ok {
+				continue
+			}
+			if !dependency.SatisfiedBy(dependencyRecipe.pkg.Version) {
+				return nil, fmt.Errorf("%s requires %s, %s defines %s", r.pkg.Name, dependency.String(), dependencyRecipe.path, dependencyRecipe.pkg.Version)
+			}
+			r.depends = append(r.depends, dependency.Name)
+		}
+		sort.Strings(r.depends)
+	}
+
+	return recipes, nil
+}
+
+// buildOrder sorts recipes so that every package comes after its dependencies. Packages are otherwise
+// ordered by name. Dependency cycles are reported with every package taking part in them.
+func buildOrder(recipes map[string]*recipe) ([]*recipe, error) {
+	names := make([]string, 0, len(recipes))
+	for name := range recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	order := make([]*recipe, 0, len(recipes))
+	done := make(map[string]bool)
+	path := make([]string, 0)
+	var visit func(name string) error
+	visit = func(name string) error {
+		if done[name] {
+			return nil
+		}
+		for i, visiting := range path {
+			if visiting == name {
+				return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(path[i:], " -> "), name)
+			}
+		}
+
+		path = append(path, name)
+		for _, dependency := range recipes[name].depends {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+
+		done[name] = true
+		order = append(order, recipes[name])
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// upToDate checks if archive of recipe holds the same package version and is newer than the definition and
+// archives of dependencies.
+func (r *recipe) upToDate(recipes map[string]*recipe) (bool, error) {
+	archiveInfo, err := os.Stat(r.archive)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	definitionInfo, err := os.Stat(r.path)
+	if err != nil {
+		return false, err
+	}
+	if !archiveInfo.ModTime().After(definitionInfo.ModTime()) {
+		return false, nil
+	}
+	for _, dependency := range r.depends {
+		dependencyInfo, err := os.Stat(recipes[dependency].archive)
+		if err != nil {
+			return false, err
+		}
+		if dependencyInfo.ModTime().After(archiveInfo.ModTime()) {
+			return false, nil
+		}
+	}
+
+	pkg, _, err := readArchiveDefinition(r.archive)
+	if err != nil {
+		return false, nil
+	}
+
+	return pkg.Name == r.pkg.Name && pkg.Version == r.pkg.Version, nil
+}
+
+// stagePackage installs package archive into staging directory of batch build. Staging directory only
+// provides files for later builds, so install scripts are not run and dependencies are not checked.
+func stagePackage(archivePath, stagingDir string, verbose bool) error {
+	pkg, packageDir, err := extractPackage(archivePath)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(packageDir)
+	db, err := openDatabase(stagingDir)
+	if err != nil {
+		return err
+	}
+	if err := checkFileConflicts(db, stagingDir, pkg, packageDir, nil); err != nil {
+		return err
+	}
+
+	tx := newTransaction()
+	options := InstallOptions{Verbose: verbose, SkipScripts: true}
+	if err := installPackage(tx, db, pkg, packageDir, stagingDir, InstallReasonExplicit, options); err != nil {
+		return tx.abort(err)
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(packageDir)
+}
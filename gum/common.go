@@ -1,48 +1,80 @@
 package gum
 
 import (
+	"errors"
 	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"sort"
+	"syscall"
 )
 
 const (
-	scriptCommand        = "bash"
-	rootUidString        = "0"
-	currentDirPathString = "."
+	scriptCommand = "bash"
+	rootUidString = "0"
+
+	// lockDirName holds lock files of work directories, package names cannot start with dot, so it is never
+	// a work directory itself
+	lockDirName = ".locks"
 )
 
-// SetEnvVars sets environment variables.
-func SetEnvVars(buildDir string, fakeRootDir string) error {
-	if err := os.Setenv(BuildDirEnvVarName, buildDir); err != nil {
-		return err
-	}
-	if err := os.Setenv(FakeRootDirEnvVarName, fakeRootDir); err != nil {
-		return err
-	}
+// runScriptInDir executes bash script in separate process working in dir. Script gets environment env,
+// or environment of the current process if env is nil.
+func runScriptInDir(dir, logic string, env []string, output scriptOutput) error {
+	cmd := exec.Command(scriptCommand)
+	cmd.Dir = dir
+	cmd.Env = env
 
-	return nil
+	return runScript(cmd, logic, output)
 }
 
-// runScriptInDir executes bash script in separate process.
-func runScriptInDir(dir, logic string, verbose bool) error {
-	currentDir, err := os.Getwd()
+// runInstallScript executes package script against system at root, working in dir. Scripts for alternate roots
+// run chrooted into them, so root has to provide bash, and work in its root directory instead. Scripts get
+// configured build and fake root directories in environment, as install scripts always did.
+func runInstallScript(root, dir, logic string, output scriptOutput) error {
+	config := currentConfig()
+	buildDir, err := filepath.Abs(config.BuildDir)
 	if err != nil {
 		return err
 	}
-
-	err = os.Chdir(dir)
+	fakeRootDir, err := filepath.Abs(config.FakeRootDir)
 	if err != nil {
 		return err
 	}
 
 	cmd := exec.Command(scriptCommand)
-	if verbose {
-		cmd.Stderr = os.Stderr
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), BuildDirEnvVarName+"="+buildDir, FakeRootDirEnvVarName+"="+fakeRootDir)
+	if filepath.Clean(root) != RootDir {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Chroot: root}
+		cmd.Dir = RootDir
+	}
+
+	return runScript(cmd, logic, output)
+}
+
+// runScript feeds script to standard input of command and waits for it to finish. Output of script is logged,
+// failures are reported with the end of the log.
+func runScript(cmd *exec.Cmd, logic string, output scriptOutput) error {
+	logFile := output.open()
+	if logFile != nil {
+		defer logFile.Close()
+	}
+	switch {
+	case output.verbose && logFile != nil:
+		cmd.Stdout = io.MultiWriter(os.Stdout, logFile)
+		cmd.Stderr = io.MultiWriter(os.Stderr, logFile)
+	case output.verbose:
 		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	case logFile != nil:
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
 	}
 
 	stdin, err := cmd.StdinPipe()
@@ -59,80 +91,141 @@ func runScriptInDir(dir, logic string, verbose bool) error {
 		return err
 	}
 	if err := cmd.Wait(); err != nil {
-		return err
-	}
-
-	err = os.Chdir(currentDir)
-	if err != nil {
-		return err
+		return output.failure(err, logFile)
 	}
 
 	return nil
 }
 
-// cleanUpDirs removes directory trees at specified paths.
-func cleanUpDirs(buildDir, fakeRootDir, tempDir string) error {
-	if err := os.RemoveAll(buildDir); err != nil {
-		return err
+// workDirs are directories used by a single build, named after the package inside configured directories.
+// Names do not change between builds, so paths recorded by build tools do not break reproducibility. Each
+// directory is guarded by lock file in lockDirName of its parent, so builds of the same package wait for each other, while builds
+// of different packages run at the same time.
+type workDirs struct {
+	build    string
+	fakeRoot string
+	temp     string
+	locks    []*os.File
+}
+
+// newWorkDirs creates build, fake root and temp directories of package inside given parent directories.
+func newWorkDirs(buildDir, fakeRootDir, tempDir, name string) (*workDirs, error) {
+	if err := validatePackageName(name); err != nil {
+		return nil, err
 	}
-	if err := os.RemoveAll(fakeRootDir); err != nil {
-		return err
+	if name == "" {
+		return nil, errors.New("missing package name")
 	}
-	if err := os.RemoveAll(tempDir); err != nil {
-		return err
+
+	dirs := &workDirs{}
+	for _, dir := range []struct {
+		parent string
+		path   *string
+	}{{buildDir, &dirs.build}, {fakeRootDir, &dirs.fakeRoot}, {tempDir, &dirs.temp}} {
+		path, lock, err := lockWorkDir(dir.parent, name)
+		if err != nil {
+			dirs.remove()
+			return nil, err
+		}
+		*dir.path = path
+		dirs.locks = append(dirs.locks, lock)
 	}
 
-	return nil
+	return dirs, nil
 }
 
-// prepareDirs cleans up and creates directories.
-func prepareDirs(buildDir, fakeRootDir, tempDir string) error {
-	if err := cleanUpDirs(buildDir, fakeRootDir, tempDir); err != nil {
-		return err
+// lockWorkDir takes lock of directory name inside parent, waiting for other build holding it, and recreates
+// the directory empty.
+func lockWorkDir(parent, name string) (string, *os.File, error) {
+	lockDir := filepath.Join(parent, lockDirName)
+	if err := os.MkdirAll(lockDir, os.ModePerm); err != nil {
+		return "", nil, err
 	}
-	if err := os.MkdirAll(buildDir, os.ModePerm); err != nil {
-		return err
+	path := filepath.Join(parent, name)
+	lock, err := os.OpenFile(filepath.Join(lockDir, name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", nil, err
 	}
-	if err := os.MkdirAll(fakeRootDir, os.ModePerm); err != nil {
-		return err
+	err = unix.Flock(int(lock.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		fmt.Fprintf(os.Stderr, "waiting for another build using %s\n", path)
+		err = unix.Flock(int(lock.Fd()), unix.LOCK_EX)
 	}
-	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
-		return err
+	if err != nil {
+		lock.Close()
+		return "", nil, fmt.Errorf("lock %s: %w", path, err)
 	}
 
-	return nil
+	// left over by build that was interrupted
+	if err := os.RemoveAll(path); err != nil {
+		lock.Close()
+		return "", nil, err
+	}
+	if err := os.Mkdir(path, os.ModePerm); err != nil {
+		lock.Close()
+		return "", nil, err
+	}
+
+	return path, lock, nil
 }
 
-// listFiles returns listing of all files in directory tree.
-func listFiles(dir string) ([]string, error) {
-	currentDir, err := os.Getwd()
-	if err != nil {
-		return nil, err
+// makeWorkDir creates new directory with unique name starting with name inside parent.
+func makeWorkDir(parent, name string) (string, error) {
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return "", err
 	}
-	err = os.Chdir(dir)
-	if err != nil {
-		return nil, err
+
+	return os.MkdirTemp(parent, name+"-")
+}
+
+// remove deletes work directories and everything in them, and releases their locks. Lock files are kept,
+// so builds waiting for them keep locking the same file.
+func (d *workDirs) remove() error {
+	var removeErr error
+	for _, dir := range []string{d.build, d.fakeRoot, d.temp} {
+		if dir == "" {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil && removeErr == nil {
+			removeErr = err
+		}
+	}
+	// directories may be taken by another build once locks are released, so they are not removed again
+	d.build, d.fakeRoot, d.temp = "", "", ""
+	for _, lock := range d.locks {
+		lock.Close()
 	}
+	d.locks = nil
+
+	return removeErr
+}
+
+// all returns every work directory.
+func (d *workDirs) all() []string {
+	return []string{d.build, d.fakeRoot, d.temp}
+}
 
+// listFiles returns sorted paths of all files in directory tree, relative to dir.
+func listFiles(dir string) ([]string, error) {
 	files := make([]string, 0)
-	err = filepath.Walk(currentDirPathString, func(path string, info fs.FileInfo, err error) error { // TODO: error?
-		if path == currentDirPathString {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
 			return nil
 		}
-		//if f, err := os.Stat(path); err != nil || f.IsDir() {
-		//	return nil
-		//}
-		files = append(files, path)
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, relativePath)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-
-	err = os.Chdir(currentDir)
-	if err != nil {
-		return nil, err
-	}
+	sort.Strings(files)
 
 	return files, nil
 }
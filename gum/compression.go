@@ -0,0 +1,115 @@
+package gum
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionXz   = "xz"
+	CompressionZstd = "zstd"
+
+	// DefaultCompressionLevel selects default level of the codec.
+	DefaultCompressionLevel = 0
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// xzDictionarySizes maps xz preset levels to dictionary capacity.
+	xzDictionarySizes = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+)
+
+// CompressionCodecs lists supported package archive compression codecs.
+var CompressionCodecs = []string{CompressionNone, CompressionGzip, CompressionXz, CompressionZstd}
+
+// CompressionExtension returns file name extension appended to archives compressed with codec.
+func CompressionExtension(codec string) string {
+	switch codec {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionXz:
+		return ".xz"
+	case CompressionZstd:
+		return ".zst"
+	}
+
+	return ""
+}
+
+// newCompressingWriter wraps writer with compressor of codec. Level 0 selects codec default.
+// Zstandard runs on a single thread, so output does not depend on number of processors.
+func newCompressingWriter(writer io.Writer, codec string, level int) (io.WriteCloser, error) {
+	switch codec {
+	case CompressionNone, "":
+		return nopWriteCloser{writer}, nil
+	case CompressionGzip:
+		if level == DefaultCompressionLevel {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(writer, level)
+	case CompressionXz:
+		config := xz.WriterConfig{}
+		if level != DefaultCompressionLevel {
+			if level < 0 || level >= len(xzDictionarySizes) {
+				return nil, fmt.Errorf("invalid xz compression level %d", level)
+			}
+			config.DictCap = xzDictionarySizes[level]
+		}
+		return config.NewWriter(writer)
+	case CompressionZstd:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != DefaultCompressionLevel {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(writer, options...)
+	}
+
+	return nil, fmt.Errorf("unknown compression codec %s", codec)
+}
+
+// newDecompressingReader detects compression of stream by its magic bytes and returns reader
+// of decompressed content. Uncompressed streams are returned unchanged.
+func newDecompressingReader(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, xzMagic):
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader.IOReadCloser(), nil
+	}
+
+	return io.NopCloser(buffered), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
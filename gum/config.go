@@ -0,0 +1,246 @@
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ConfigFileEnvVarName selects configuration file used instead of DefaultConfigFile.
+	ConfigFileEnvVarName = "GUMSHIELD_CONFIG"
+	configEnvVarPrefix   = "GUMSHIELD_CONF_"
+
+	ConfigSourceDefault = "default"
+	configListSeparator = ","
+)
+
+// Config holds settings of gumshield read from configuration file, overridden by environment variables.
+type Config struct {
+	BuildDir         string   `yaml:"build_dir"`
+	FakeRootDir      string   `yaml:"fake_root_dir"`
+	TempDir          string   `yaml:"temp_dir"`
+	IndexDir         string   `yaml:"index_dir"`
+	CacheDir         string   `yaml:"cache_dir"`
+	LogDir           string   `yaml:"log_dir"`
+	Repositories     []string `yaml:"repositories"`
+	Jobs             int      `yaml:"jobs"`
+	Compression      string   `yaml:"compression"`
+	CompressionLevel int      `yaml:"compression_level"`
+	KeyringDir       string   `yaml:"keyring_dir"`
+	SignKey          string   `yaml:"sign_key"`
+
+	sources map[string]string
+}
+
+// ConfigValue is effective value of configuration key with description of where it comes from.
+type ConfigValue struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+}
+
+// configKey binds configuration key to field of Config, converting its value from and to text.
+type configKey struct {
+	name string
+	get  func(c *Config) string
+	set  func(c *Config, value string) error
+}
+
+func stringConfigKey(name string, field func(c *Config) *string) configKey {
+	return configKey{
+		name: name,
+		get:  func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+func intConfigKey(name string, field func(c *Config) *int) configKey {
+	return configKey{
+		name: name,
+		get:  func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, value string) error {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a number", name, value)
+			}
+			*field(c) = number
+			return nil
+		},
+	}
+}
+
+// configKeys lists every configuration key, in order shown by show config.
+var configKeys = []configKey{
+	stringConfigKey("build_dir", func(c *Config) *string { return &c.BuildDir }),
+	stringConfigKey("fake_root_dir", func(c *Config) *string { return &c.FakeRootDir }),
+	stringConfigKey("temp_dir", func(c *Config) *string { return &c.TempDir }),
+	stringConfigKey("index_dir", func(c *Config) *string { return &c.IndexDir }),
+	stringConfigKey("cache_dir", func(c *Config) *string { return &c.CacheDir }),
+	stringConfigKey("log_dir", func(c *Config) *string { return &c.LogDir }),
+	{
+		name: "repositories",
+		get:  func(c *Config) string { return strings.Join(c.Repositories, configListSeparator) },
+		set: func(c *Config, value string) error {
+			c.Repositories = make([]string, 0)
+			for _, repository := range strings.Split(value, configListSeparator) {
+				if repository = strings.TrimSpace(repository); repository != "" {
+					c.Repositories = append(c.Repositories, repository)
+				}
+			}
+			return nil
+		},
+	},
+	intConfigKey("jobs", func(c *Config) *int { return &c.Jobs }),
+	stringConfigKey("compression", func(c *Config) *string { return &c.Compression }),
+	intConfigKey("compression_level", func(c *Config) *int { return &c.CompressionLevel }),
+	stringConfigKey("keyring_dir", func(c *Config) *string { return &c.KeyringDir }),
+	stringConfigKey("sign_key", func(c *Config) *string { return &c.SignKey }),
+}
+
+var (
+	loadedConfig    *Config
+	loadedConfigErr error
+	loadConfigOnce  sync.Once
+)
+
+// DefaultConfig returns configuration used when no configuration file exists.
+func DefaultConfig() *Config {
+	c := &Config{
+		BuildDir:         DefaultBuildDir,
+		FakeRootDir:      DefaultFakeRootDir,
+		TempDir:          DefaultTempDir,
+		IndexDir:         DefaultIndexDir,
+		CacheDir:         DefaultCacheDir,
+		LogDir:           DefaultLogDir,
+		Repositories:     []string{},
+		Jobs:             DefaultJobs,
+		Compression:      CompressionZstd,
+		CompressionLevel: DefaultCompressionLevel,
+		KeyringDir:       KeyringDir,
+		sources:          make(map[string]string),
+	}
+	for _, key := range configKeys {
+		c.sources[key.name] = ConfigSourceDefault
+	}
+
+	return c
+}
+
+// LoadConfig reads configuration file, DefaultConfigFile or file named by GUMSHIELD_CONFIG, and applies
+// GUMSHIELD_CONF_<KEY> environment variables over it. Configuration is read once and shared by every command.
+func LoadConfig() (*Config, error) {
+	loadConfigOnce.Do(func() {
+		path := DefaultConfigFile
+		if env, ok := os.LookupEnv(ConfigFileEnvVarName); ok {
+			path = env
+		}
+		loadedConfig, loadedConfigErr = readConfig(path, os.LookupEnv)
+	})
+
+	return loadedConfig, loadedConfigErr
+}
+
+// currentConfig returns loaded configuration, or defaults when it could not be loaded.
+func currentConfig() *Config {
+	c, err := LoadConfig()
+	if err != nil {
+		return DefaultConfig()
+	}
+
+	return c
+}
+
+// readConfig reads configuration file at path, missing file leaves defaults, and applies environment
+// variables found by lookupEnv over it.
+func readConfig(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := DefaultConfig()
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		keys := make(map[string]interface{})
+		if err := yaml.Unmarshal(content, &keys); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		unknown := make([]string, 0)
+		for name := range keys {
+			if findConfigKey(name) == nil {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return nil, fmt.Errorf("%s: unknown keys %s", path, strings.Join(unknown, ", "))
+		}
+		if err := yaml.Unmarshal(content, c); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for name := range keys {
+			c.sources[name] = path
+		}
+	}
+
+	for _, key := range configKeys {
+		envVarName := configEnvVarPrefix + strings.ToUpper(key.name)
+		value, ok := lookupEnv(envVarName)
+		if !ok {
+			continue
+		}
+		if err := key.set(c, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", envVarName, err)
+		}
+		c.sources[key.name] = envVarName
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func findConfigKey(name string) *configKey {
+	for i := range configKeys {
+		if configKeys[i].name == name {
+			return &configKeys[i]
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validate() error {
+	supported := false
+	for _, codec := range CompressionCodecs {
+		supported = supported || codec == c.Compression
+	}
+	if !supported {
+		return fmt.Errorf("compression: unsupported codec %q, expected one of %s", c.Compression, strings.Join(CompressionCodecs, ", "))
+	}
+	if c.Jobs < 1 {
+		return errors.New("jobs: has to be at least 1")
+	}
+
+	return nil
+}
+
+// Values returns effective value and source of every configuration key.
+func (c *Config) Values() []ConfigValue {
+	values := make([]ConfigValue, 0, len(configKeys))
+	for _, key := range configKeys {
+		values = append(values, ConfigValue{Key: key.name, Value: key.get(c), Source: c.sources[key.name]})
+	}
+
+	return values
+}
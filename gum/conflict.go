@@ -0,0 +1,108 @@
+package gum
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileConflict describes a package file that would overwrite a file of another package or an untracked file.
+type fileConflict struct {
+	path   string
+	reason string
+}
+
+// checkFileConflicts compares entries of package files archive with files owned by installed packages
+// and with files existing in root. Files of installed package with the same name are replaced
+// by upgrade, so they never conflict. Paths matching any of overwrite globs are not reported.
+func checkFileConflicts(db *packageDatabase, root string, pkg *PackageDefinition, packageDir string, overwrite []string) error {
+	headers, err := readTarHeaders(filepath.Join(packageDir, FilesArchiveFileName))
+	if err != nil {
+		return err
+	}
+
+	conflicts := make([]fileConflict, 0)
+	for _, header := range headers {
+		path := normalizePackagePath(header.Name)
+		owners := db.owners(path)
+		otherOwners := make([]string, 0, len(owners))
+		for _, owner := range owners {
+			if owner != pkg.Name {
+				otherOwners = append(otherOwners, owner)
+			}
+		}
+		if matchesAnyGlob(path, overwrite) || (len(owners) > 0 && len(otherOwners) == 0) {
+			continue
+		}
+
+		isDir := header.Typeflag == tar.TypeDir
+		resolve := resolveInRoot
+		if isDir {
+			// directories may be reached through symlinks, e.g. /lib pointing to /usr/lib
+			resolve = resolveDirInRoot
+		}
+		targetPath, err := resolve(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(targetPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case isDir && info.IsDir():
+			continue
+		case isDir:
+			conflicts = append(conflicts, fileConflict{path: path, reason: "exists in filesystem and is not a directory"})
+		case info.IsDir():
+			conflicts = append(conflicts, fileConflict{path: path, reason: "exists in filesystem as a directory"})
+		case len(otherOwners) > 0:
+			conflicts = append(conflicts, fileConflict{path: path, reason: "owned by " + strings.Join(otherOwners, ", ")})
+		default:
+			conflicts = append(conflicts, fileConflict{path: path, reason: "exists in filesystem"})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return formatFileConflicts(pkg.Name, conflicts)
+	}
+
+	return nil
+}
+
+func formatFileConflicts(packageName string, conflicts []fileConflict) error {
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].path < conflicts[j].path
+	})
+
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s: %d conflicting files (use --overwrite <glob> to replace them):", packageName, len(conflicts)))
+	for _, conflict := range conflicts {
+		sb.WriteString(fmt.Sprintf("\n  %s: %s", conflict.path, conflict.reason))
+	}
+
+	return fmt.Errorf("%s", sb.String())
+}
+
+// normalizePackagePath converts archive or manifest path to absolute path inside the target root.
+func normalizePackagePath(path string) string {
+	return filepath.Join(RootDir, path)
+}
+
+// matchesAnyGlob checks if path matches any of shell patterns.
+func matchesAnyGlob(path string, globs []string) bool {
+	for _, glob := range globs {
+		if matched, err := filepath.Match(glob, path); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,17 +4,31 @@ const (
 	DefaultBuildDir    = "/tmp/gumshield/build"
 	DefaultFakeRootDir = "/tmp/gumshield/fake_root"
 	DefaultTempDir     = "/tmp/gumshield/temp"
+	DefaultStagingDir  = "/tmp/gumshield/staging"
 	DefaultIndexDir    = "/var/lib/gumshield"
 	RootDir            = "/"
-
-	// DefaultConfigFile = "/etc/gumshield" // TODO: config
+	DefaultConfigDir   = "/etc/gumshield"
+	KeyringDir         = DefaultConfigDir + "/keys"
+	DefaultCacheDir    = "/var/cache/gumshield"
+	DefaultLogDir      = "/var/log/gumshield"
+	DefaultConfigFile  = DefaultConfigDir + "/gumshield.conf"
+	DefaultJobs        = 1
 
 	DefinitionFileName   = "manifest"
 	FilesArchiveFileName = "files.tar"
+	DatabaseFileName     = "packages.json"
+	SignatureFileName    = "signature"
+
+	RepositoryIndexFileName = "index.json"
 
 	DefinitionFileExtension = ".elplan"
 	ArchiveFileExtension    = ".tar"
 
-	BuildDirEnvVarName    = "GUMSHIELD_BUILD_DIR"
-	FakeRootDirEnvVarName = "GUMSHIELD_FAKE_ROOT_DIR"
+	BuildDirEnvVarName      = "GUMSHIELD_BUILD_DIR"
+	FakeRootDirEnvVarName   = "GUMSHIELD_FAKE_ROOT_DIR"
+	OwnershipFileEnvVarName = "GUMSHIELD_OWNERSHIP_FILE"
+	StagingDirEnvVarName    = "GUMSHIELD_STAGING_DIR"
+
+	// SandboxEnvVarName passes writable directories to the process setting up build sandbox.
+	SandboxEnvVarName = "GUMSHIELD_SANDBOX_WRITABLE_DIRS"
 )
@@ -0,0 +1,428 @@
+package gum
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	// DatabaseSchemaVersion is version of package database layout written by this version of gumshield.
+	DatabaseSchemaVersion = 2
+
+	InstallReasonExplicit   = "explicit"
+	InstallReasonDependency = "dependency"
+
+	migratedIndexDirName = "manifests.migrated"
+
+	fileTypeRegular   = "file"
+	fileTypeDirectory = "directory"
+	fileTypeSymlink   = "symlink"
+	fileTypeHardLink  = "hardlink"
+	fileTypeFifo      = "fifo"
+	fileTypeChar      = "char"
+	fileTypeBlock     = "block"
+)
+
+// packageDatabase holds every package installed in system, stored in a single file. Owners index maps
+// every installed path to packages containing it.
+type packageDatabase struct {
+	Schema   int                          `json:"schema"`
+	Packages map[string]*InstalledPackage `json:"packages"`
+	Owners   map[string][]string          `json:"owners"`
+
+	path string
+}
+
+// databasePath returns path of package database of system installed in root.
+func databasePath(root string) string {
+	return filepath.Join(indexDir(root), DatabaseFileName)
+}
+
+// openDatabase reads package database of system installed in root. When there is no database yet,
+// package definitions found in the index directory by older versions are migrated into it.
+func openDatabase(root string) (*packageDatabase, error) {
+	path := databasePath(root)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return migrateIndex(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db := &packageDatabase{path: path}
+	if err := json.Unmarshal(content, db); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if db.Schema > DatabaseSchemaVersion {
+		return nil, fmt.Errorf("%s: database schema %d is newer than supported %d", path, db.Schema, DatabaseSchemaVersion)
+	}
+	if db.Packages == nil {
+		db.Packages = make(map[string]*InstalledPackage)
+	}
+	// owners index was added in schema 2
+	if db.Schema < 2 || db.Owners == nil {
+		db.rebuildOwners()
+	}
+	db.Schema = DatabaseSchemaVersion
+
+	return db, nil
+}
+
+// migrateIndex creates database from package definitions stored in index directory, one file per package.
+// Migrated definitions are moved aside, definitions that cannot be parsed are reported and left in place.
+func migrateIndex(root string) (*packageDatabase, error) {
+	db := &packageDatabase{
+		Schema:   DatabaseSchemaVersion,
+		Packages: make(map[string]*InstalledPackage),
+		Owners:   make(map[string][]string),
+		path:     databasePath(root),
+	}
+
+	entries, err := os.ReadDir(indexDir(root))
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != DefinitionFileExtension {
+			continue
+		}
+		filePath := filepath.Join(indexDir(root), entry.Name())
+		pkg, err := migratePackage(root, filePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: not migrating %s: %v\n", filePath, err)
+			continue
+		}
+		db.put(pkg)
+		migrated = append(migrated, filePath)
+	}
+	if len(migrated) == 0 {
+		return db, nil
+	}
+
+	// readers without write access to the index keep using migrated database in memory
+	if err := db.save(); errors.Is(err, os.ErrPermission) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	migratedDir := filepath.Join(indexDir(root), migratedIndexDirName)
+	if err := os.MkdirAll(migratedDir, 0755); err != nil {
+		return nil, err
+	}
+	for _, filePath := range migrated {
+		if err := os.Rename(filePath, filepath.Join(migratedDir, filepath.Base(filePath))); err != nil {
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// migratePackage converts package definition from index directory to database record. As archive headers
+// are not available any more, file records are taken from files present in root.
+func migratePackage(root, filePath string) (*InstalledPackage, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := ParsePackageDefinition(string(content))
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateInstalledDefinition(pkg); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]*tar.Header, 0, len(pkg.Files))
+	for _, file := range pkg.Files {
+		header := &tar.Header{Name: file}
+		if path, err := resolveInRoot(root, file); err == nil {
+			if info, err := os.Lstat(path); err == nil {
+				target, _ := os.Readlink(path)
+				if fileHeader, err := tar.FileInfoHeader(info, target); err == nil {
+					header = fileHeader
+					header.Name = file
+				}
+			}
+		}
+		headers = append(headers, header)
+	}
+
+	installed := newInstalledPackage(pkg, string(content), headers, InstallReasonExplicit, nil)
+	installed.InstallTime = info.ModTime()
+
+	return installed, nil
+}
+
+// newInstalledPackage creates database record of package with definition content and headers of its files archive.
+// Owners are recorded as resolved with ids of the target system, checksums are taken from package definition.
+func newInstalledPackage(pkg *PackageDefinition, definition string, headers []*tar.Header, reason string, ids *idMap) *InstalledPackage {
+	entries := make(map[string]PackageFile, len(pkg.FileEntries))
+	for _, entry := range pkg.FileEntries {
+		entries[normalizePackagePath(entry.Path)] = entry
+	}
+
+	files := make([]FileRecord, 0, len(headers))
+	for _, header := range headers {
+		record := newFileRecord(header)
+		record.Uid, record.Gid = ids.owner(header)
+		if entry, ok := entries[record.Path]; ok {
+			record.Sha256 = entry.Sha256
+			// hard links have no size in archive, their entries carry size of the linked file
+			if record.Type == fileTypeHardLink {
+				record.Size = entry.Size
+			}
+		}
+		files = append(files, record)
+	}
+
+	return &InstalledPackage{
+		Name:            pkg.Name,
+		Version:         pkg.Version,
+		Description:     pkg.Description,
+		Depends:         pkg.Depends,
+		OptionalDepends: pkg.OptionalDepends,
+		InstallTime:     time.Now().UTC(),
+		Reason:          reason,
+		Files:           files,
+		Definition:      definition,
+	}
+}
+
+// recordDirectories replaces mode and owner of directory records with those found in root. Directories that
+// existed before install keep their own, so values from archive would be reported as drift right after install.
+func (p *InstalledPackage) recordDirectories(root string) error {
+	for i := range p.Files {
+		record := &p.Files[i]
+		if record.Type != fileTypeDirectory {
+			continue
+		}
+		path, err := resolveInRoot(root, record.Path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		record.Mode = unixPermissions(info.Mode())
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			record.Uid, record.Gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+
+	return nil
+}
+
+func newFileRecord(header *tar.Header) FileRecord {
+	record := FileRecord{
+		Path: normalizePackagePath(header.Name),
+		Mode: header.Mode & 07777,
+		Uid:  header.Uid,
+		Gid:  header.Gid,
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg:
+		record.Type, record.Size = fileTypeRegular, header.Size
+	case tar.TypeDir:
+		record.Type = fileTypeDirectory
+	case tar.TypeSymlink:
+		record.Type, record.Target = fileTypeSymlink, header.Linkname
+	case tar.TypeLink:
+		record.Type, record.Target = fileTypeHardLink, normalizePackagePath(header.Linkname)
+	case tar.TypeFifo:
+		record.Type = fileTypeFifo
+	case tar.TypeChar:
+		record.Type = fileTypeChar
+	case tar.TypeBlock:
+		record.Type = fileTypeBlock
+	}
+
+	return record
+}
+
+// paths returns paths of every file of package.
+func (p *InstalledPackage) paths() []string {
+	paths := make([]string, 0, len(p.Files))
+	for _, file := range p.Files {
+		paths = append(paths, file.Path)
+	}
+
+	return paths
+}
+
+// definition parses package definition package was installed from.
+func (p *InstalledPackage) definition() (*PackageDefinition, error) {
+	pkg, err := ParsePackageDefinition(p.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Name, err)
+	}
+
+	return pkg, nil
+}
+
+// get returns installed package with name.
+func (db *packageDatabase) get(name string) (*InstalledPackage, error) {
+	pkg, ok := db.Packages[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: no such package", name)
+	}
+
+	return pkg, nil
+}
+
+// list returns installed packages sorted by name.
+func (db *packageDatabase) list() []*InstalledPackage {
+	packages := make([]*InstalledPackage, 0, len(db.Packages))
+	for _, pkg := range db.Packages {
+		packages = append(packages, pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
+	})
+
+	return packages
+}
+
+// put adds package to database, replacing installed package with the same name.
+func (db *packageDatabase) put(pkg *InstalledPackage) {
+	db.remove(pkg.Name)
+	db.Packages[pkg.Name] = pkg
+	for _, path := range pkg.paths() {
+		db.Owners[path] = append(db.Owners[path], pkg.Name)
+		sort.Strings(db.Owners[path])
+	}
+}
+
+func (db *packageDatabase) remove(name string) {
+	pkg, ok := db.Packages[name]
+	if !ok {
+		return
+	}
+	delete(db.Packages, name)
+
+	for _, path := range pkg.paths() {
+		owners := make([]string, 0, len(db.Owners[path]))
+		for _, owner := range db.Owners[path] {
+			if owner != name {
+				owners = append(owners, owner)
+			}
+		}
+		if len(owners) == 0 {
+			delete(db.Owners, path)
+		} else {
+			db.Owners[path] = owners
+		}
+	}
+}
+
+// rebuildOwners recreates owners index from files of installed packages.
+func (db *packageDatabase) rebuildOwners() {
+	packages := db.Packages
+	db.Packages = make(map[string]*InstalledPackage, len(packages))
+	db.Owners = make(map[string][]string)
+	for _, pkg := range packages {
+		db.put(pkg)
+	}
+}
+
+// owners returns names of packages containing path.
+func (db *packageDatabase) owners(path string) []string {
+	return db.Owners[normalizePackagePath(path)]
+}
+
+// ownedByOthers checks if any package other than name owns file, e.g. after installing with --overwrite.
+func ownedByOthers(db *packageDatabase, file, name string) bool {
+	for _, owner := range db.owners(file) {
+		if owner != name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// save atomically replaces database file with current content.
+func (db *packageDatabase) save() error {
+	if err := os.MkdirAll(filepath.Dir(db.path), 0755); err != nil {
+		return err
+	}
+	tempPath := db.path + stagedFileSuffix
+	if err := db.write(tempPath); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, db.path)
+}
+
+// stage writes database as part of transaction, so it is restored when transaction is rolled back.
+func (db *packageDatabase) stage(tx *transaction) error {
+	if err := os.MkdirAll(filepath.Dir(db.path), 0755); err != nil {
+		return err
+	}
+
+	return tx.create(db.path, db.write)
+}
+
+// write writes database to file at path and flushes it to disk.
+func (db *packageDatabase) write(path string) error {
+	content, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// isInstalled returns error when package with the same name is already installed.
+func (db *packageDatabase) isInstalled(pkg *PackageDefinition) error {
+	installed, ok := db.Packages[pkg.Name]
+	if !ok {
+		return nil
+	}
+
+	relation := "same"
+	if result, err := CompareVersions(pkg.Version, installed.Version); err == nil && result > 0 {
+		relation = "newer"
+	} else if err == nil && result < 0 {
+		relation = "older"
+	}
+
+	return fmt.Errorf("package already installed in version %s, archive contains %s version %s, use upgrade to replace it", installed.Version, relation, pkg.Version)
+}
@@ -0,0 +1,174 @@
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	constraintSeparator = ","
+	operatorCharacters  = "<>=!"
+)
+
+// Dependency is a package name with optional version constraints, e.g. openssl>=3.0,<4.
+type Dependency struct {
+	Name        string
+	Constraints []VersionConstraint
+}
+
+// VersionConstraint restricts acceptable versions of a dependency.
+type VersionConstraint struct {
+	Operator string
+	Version  Version
+}
+
+// ParseDependency parses dependency expression in form name[op version[,op version]...].
+func ParseDependency(expression string) (Dependency, error) {
+	dependency := Dependency{}
+	expression = strings.TrimSpace(expression)
+
+	nameEnd := strings.IndexAny(expression, operatorCharacters)
+	if nameEnd < 0 {
+		nameEnd = len(expression)
+	}
+	dependency.Name = strings.TrimSpace(expression[:nameEnd])
+	if dependency.Name == "" {
+		return dependency, fmt.Errorf("missing package name in dependency %q", expression)
+	}
+
+	rest := strings.TrimSpace(expression[nameEnd:])
+	if rest == "" {
+		return dependency, nil
+	}
+	for _, part := range strings.Split(rest, constraintSeparator) {
+		constraint, err := parseVersionConstraint(part)
+		if err != nil {
+			return dependency, fmt.Errorf("dependency %q: %w", expression, err)
+		}
+		dependency.Constraints = append(dependency.Constraints, constraint)
+	}
+
+	return dependency, nil
+}
+
+func parseVersionConstraint(expression string) (VersionConstraint, error) {
+	expression = strings.TrimSpace(expression)
+	operatorEnd := 0
+	for operatorEnd < len(expression) && strings.ContainsRune(operatorCharacters, rune(expression[operatorEnd])) {
+		operatorEnd++
+	}
+
+	operator := expression[:operatorEnd]
+	switch operator {
+	case "==":
+		operator = "="
+	case "=", "!=", "<", "<=", ">", ">=":
+	case "":
+		return VersionConstraint{}, errors.New("missing constraint operator")
+	default:
+		return VersionConstraint{}, fmt.Errorf("unknown constraint operator %q", operator)
+	}
+
+	version, err := ParseVersion(expression[operatorEnd:])
+	if err != nil {
+		return VersionConstraint{}, err
+	}
+
+	return VersionConstraint{Operator: operator, Version: version}, nil
+}
+
+// SatisfiedBy checks if version matches every constraint of the dependency.
+func (d Dependency) SatisfiedBy(version string) bool {
+	if len(d.Constraints) == 0 {
+		return true
+	}
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false
+	}
+
+	for _, constraint := range d.Constraints {
+		if !constraint.SatisfiedBy(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SatisfiedBy checks if version matches the constraint.
+func (c VersionConstraint) SatisfiedBy(version Version) bool {
+	result := version.Compare(c.Version)
+	switch c.Operator {
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+
+	return false
+}
+
+func (d Dependency) String() string {
+	constraints := make([]string, 0, len(d.Constraints))
+	for _, constraint := range d.Constraints {
+		constraints = append(constraints, constraint.Operator+constraint.Version.String())
+	}
+
+	return d.Name + strings.Join(constraints, constraintSeparator)
+}
+
+// parseDependencies parses every dependency expression in list.
+func parseDependencies(expressions []string) ([]Dependency, error) {
+	dependencies := make([]Dependency, 0, len(expressions))
+	for _, expression := range expressions {
+		dependency, err := ParseDependency(expression)
+		if err != nil {
+			return nil, err
+		}
+		dependencies = append(dependencies, dependency)
+	}
+
+	return dependencies, nil
+}
+
+// checkDependencies verifies that every runtime dependency of package is installed in acceptable version.
+func checkDependencies(db *packageDatabase, pkg *PackageDefinition) error {
+	if len(pkg.Depends) == 0 {
+		return nil
+	}
+
+	dependencies, err := parseDependencies(pkg.Depends)
+	if err != nil {
+		return err
+	}
+	installed := make(map[string]string, len(db.Packages))
+	for _, p := range db.Packages {
+		installed[p.Name] = p.Version
+	}
+
+	unsatisfied := make([]string, 0)
+	for _, dependency := range dependencies {
+		version, ok := installed[dependency.Name]
+		switch {
+		case !ok:
+			unsatisfied = append(unsatisfied, dependency.String()+" (not installed)")
+		case !dependency.SatisfiedBy(version):
+			unsatisfied = append(unsatisfied, dependency.String()+" (installed "+version+")")
+		}
+	}
+	if len(unsatisfied) > 0 {
+		return fmt.Errorf("unsatisfied dependencies of %s: %s", pkg.Name, strings.Join(unsatisfied, ", "))
+	}
+
+	return nil
+}
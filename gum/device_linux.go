@@ -0,0 +1,8 @@
+package gum
+
+import "golang.org/x/sys/unix"
+
+// makeDevice creates character or block device node at path.
+func makeDevice(path string, mode uint32, major, minor int64) error {
+	return unix.Mknod(path, mode, int(unix.Mkdev(uint32(major), uint32(minor))))
+}
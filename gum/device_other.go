@@ -0,0 +1,10 @@
+//go:build !linux
+
+package gum
+
+import "errors"
+
+// makeDevice reports that device nodes cannot be created on systems other than Linux.
+func makeDevice(path string, mode uint32, major, minor int64) error {
+	return errors.New("creating device nodes is supported only on Linux")
+}
@@ -8,12 +8,15 @@ import (
 	"path/filepath"
 )
 
-func downloadSources(sources []string, dir string) error {
+func downloadSources(sources []Source, dir string) error {
 	for _, source := range sources {
-		_, outPath := filepath.Split(source)
-		outPath = filepath.Join(dir, outPath)
+		outPath := filepath.Join(dir, source.FileName())
 		if _, err := os.Stat(outPath); os.IsNotExist(err) {
-			if err := downloadFile(source, outPath); err != nil {
+			if err := downloadFile(source.Url, outPath); err != nil {
+				return err
+			}
+			if err := source.verifyChecksums(outPath); err != nil {
+				_ = os.Remove(outPath)
 				return err
 			}
 		}
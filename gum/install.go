@@ -1,116 +1,161 @@
 package gum
 
 import (
-	"errors"
 	"os"
 	"path/filepath"
 )
 
-func Install(archivePath, targetDir string, verbose, disableIndex bool) error {
+// InstallOptions controls how packages are installed.
+type InstallOptions struct {
+	Verbose       bool
+	DisableIndex  bool
+	AllowUnsigned bool
+	Overwrite     []string
+	// SkipScripts installs only package files, without running install scripts
+	SkipScripts bool
+}
+
+// Install installs package from archive into system at root.
+// Packages not signed by a key from keyring are refused unless AllowUnsigned is set.
+func Install(archivePath, root string, options InstallOptions) error {
 	err := isElevated()
 	if err != nil {
 		return err
 	}
 
-	absArchivePath, err := filepath.Abs(archivePath)
+	return installArchive(archivePath, root, InstallReasonExplicit, options)
+}
+
+// installArchive installs package from archive, recording it in database with install reason.
+func installArchive(archivePath, root, reason string, options InstallOptions) error {
+	pkg, packageDir, err := unpackPackage(archivePath, options.AllowUnsigned)
 	if err != nil {
 		return err
 	}
-	absBuildDir, err := filepath.Abs(DefaultBuildDir)
+	defer os.RemoveAll(packageDir)
+	db, err := openDatabase(root)
 	if err != nil {
 		return err
 	}
-	absFakeRootDir, err := filepath.Abs(DefaultFakeRootDir)
-	if err != nil {
+	if err := db.isInstalled(pkg); err != nil {
 		return err
 	}
-	absTempDir, err := filepath.Abs(DefaultTempDir)
-	if err != nil {
+	if err := checkDependencies(db, pkg); err != nil {
 		return err
 	}
-	absIndexDir, err := filepath.Abs(DefaultIndexDir)
-	if err != nil {
+	if err := ValidateInstalledDefinition(pkg); err != nil {
+		return err
+	}
+	if err := checkFileConflicts(db, root, pkg, packageDir, options.Overwrite); err != nil {
 		return err
 	}
 
-	if _, err := os.Stat(absIndexDir); os.IsNotExist(err) {
-		os.MkdirAll(absIndexDir, 0755)
+	tx := newTransaction()
+	if err := installPackage(tx, db, pkg, packageDir, root, reason, options); err != nil {
+		return tx.abort(err)
 	}
-	if err := SetEnvVars(absBuildDir, absFakeRootDir); err != nil {
+	if err := tx.commit(); err != nil {
 		return err
 	}
-	if err := prepareDirs(absBuildDir, absFakeRootDir, absTempDir); err != nil {
-		return err
+
+	return os.RemoveAll(packageDir)
+}
+
+// unpackPackage extracts package archive into new directory inside temp directory, verifies its signature
+// and reads package definition from it. Caller removes returned directory.
+func unpackPackage(archivePath string, allowUnsigned bool) (*PackageDefinition, string, error) {
+	pkg, packageDir, err := extractPackage(archivePath)
+	if err != nil {
+		return nil, "", err
 	}
-	if err := extractPackageArchive(absArchivePath, absTempDir); err != nil {
-		return err
+	if err := checkPackageSignature(packageDir, allowUnsigned); err != nil {
+		os.RemoveAll(packageDir)
+		return nil, "", err
 	}
-	pkg, err := ReadDefinitionFromFile(filepath.Join(absTempDir, DefinitionFileName))
-	if err := isInstalled(pkg.Name); err != nil {
-		return err
+
+	return pkg, packageDir, nil
+}
+
+// extractPackage extracts package archive into new directory inside temp directory and reads package
+// definition from it, without checking its signature.
+func extractPackage(archivePath string) (*PackageDefinition, string, error) {
+	absArchivePath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return nil, "", err
 	}
-	if err := ValidateInstalledDefinition(pkg); err != nil {
-		return err
+	packageDir, err := makeWorkDir(currentConfig().TempDir, filepath.Base(absArchivePath))
+	if err != nil {
+		return nil, "", err
 	}
-	if !disableIndex {
-		if err := copyDefinitionToIndex(pkg.Name, absTempDir, absIndexDir); err != nil {
-			return err
-		}
+	if err := extractPackageArchive(absArchivePath, packageDir); err != nil {
+		os.RemoveAll(packageDir)
+		return nil, "", err
+	}
+	pkg, err := ReadDefinitionFromFile(filepath.Join(packageDir, DefinitionFileName))
+	if err != nil {
+		os.RemoveAll(packageDir)
+		return nil, "", err
 	}
-	if pkg.BeforeInstallLogic != "" {
-		if err := runScriptInDir(DefaultTempDir, pkg.BeforeInstallLogic, verbose); err != nil {
+
+	return pkg, packageDir, nil
+}
+
+// installPackage runs install scripts, extracts package files and registers package in the database.
+// Every file written is recorded in transaction, so a failure at any step can be rolled back.
+func installPackage(tx *transaction, db *packageDatabase, pkg *PackageDefinition, packageDir, root, reason string, options InstallOptions) error {
+	if pkg.BeforeInstallLogic != "" && !options.SkipScripts {
+		if err := runInstallScript(root, packageDir, pkg.BeforeInstallLogic, scriptOutput{pkg: pkg.Name, stage: StageBeforeInstall, verbose: options.Verbose}); err != nil {
 			return err
 		}
 	}
-	if err := extractFilesToDir(absTempDir, targetDir); err != nil {
+	if err := extractFilesToDir(packageDir, root, tx); err != nil {
 		return err
 	}
-	if pkg.AfterInstallLogic != "" {
-		if err := runScriptInDir(DefaultTempDir, pkg.AfterInstallLogic, verbose); err != nil {
+	if !options.DisableIndex {
+		installed, err := readInstalledPackage(pkg, packageDir, root, reason)
+		if err != nil {
+			return err
+		}
+		db.put(installed)
+		if err := db.stage(tx); err != nil {
 			return err
 		}
 	}
-	if err := cleanUpDirs(absBuildDir, absFakeRootDir, absTempDir); err != nil {
-		return err
+	if pkg.AfterInstallLogic != "" && !options.SkipScripts {
+		if err := runInstallScript(root, packageDir, pkg.AfterInstallLogic, scriptOutput{pkg: pkg.Name, stage: StageAfterInstall, verbose: options.Verbose}); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func extractFilesToDir(fromDir, toDir string) error {
+func extractFilesToDir(fromDir, toDir string, tx *transaction) error {
 	filesArchive := filepath.Join(fromDir, FilesArchiveFileName)
 	archive, err := os.Open(filesArchive)
 	if err != nil {
 		return err
 	}
-	return extractTar(toDir, archive)
+	defer archive.Close()
+
+	return extractTar(FilesArchiveFileName, toDir, archive, tx)
 }
 
-func copyDefinitionToIndex(name, sourceDir, destinationDir string) error {
-	fileInfo, err := os.Stat(destinationDir)
-	if errors.Is(err, os.ErrNotExist) {
-		err := os.MkdirAll(destinationDir, 0755)
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
-		return err
-	}
-	if !fileInfo.IsDir() {
-		return errors.New(destinationDir + " is not a directory")
+// readInstalledPackage creates database record of package unpacked in packageDir and installed into root.
+func readInstalledPackage(pkg *PackageDefinition, packageDir, root, reason string) (*InstalledPackage, error) {
+	definition, err := os.ReadFile(filepath.Join(packageDir, DefinitionFileName))
+	if err != nil {
+		return nil, err
 	}
-
-	sourceFile := filepath.Join(sourceDir, DefinitionFileName)
-	input, err := os.ReadFile(sourceFile)
+	headers, err := readTarHeaders(filepath.Join(packageDir, FilesArchiveFileName))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	destinationFile := filepath.Join(destinationDir, name+DefinitionFileExtension)
-	err = os.WriteFile(destinationFile, input, 0644)
-	if err != nil {
-		return err
+	installed := newInstalledPackage(pkg, string(definition), headers, reason, loadIDMap(root))
+	if err := installed.recordDirectories(root); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return installed, nil
 }
@@ -0,0 +1,74 @@
+package gum
+
+import (
+	"archive/tar"
+	"golang.org/x/sys/unix"
+	"os"
+	"strings"
+)
+
+const xattrPaxPrefix = "SCHILY.xattr."
+
+// restoreMetadata applies ownership, mode bits, extended attributes and modification time recorded in header
+// to file at path. Ownership is restored only when running as root, user and group names are resolved
+// with ids of the target system.
+func restoreMetadata(path string, header *tar.Header, ids *idMap) error {
+	if header.Typeflag == tar.TypeLink {
+		return nil
+	}
+
+	if os.Geteuid() == 0 {
+		uid, gid := ids.owner(header)
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	// chown clears setuid bits and capabilities, so mode and extended attributes go after it
+	if header.Typeflag != tar.TypeSymlink {
+		if err := os.Chmod(path, header.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+	for key, value := range header.PAXRecords {
+		if !strings.HasPrefix(key, xattrPaxPrefix) {
+			continue
+		}
+		if err := setXattr(path, strings.TrimPrefix(key, xattrPaxPrefix), value); err != nil {
+			return err
+		}
+	}
+
+	return restoreModTime(path, header)
+}
+
+// restoreModTime sets access and modification time of path, without following symlinks.
+func restoreModTime(path string, header *tar.Header) error {
+	modTime := unix.NsecToTimespec(header.ModTime.UnixNano())
+	accessTime := modTime
+	if !header.AccessTime.IsZero() {
+		accessTime = unix.NsecToTimespec(header.AccessTime.UnixNano())
+	}
+
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{accessTime, modTime}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// recordXattrs stores extended attributes of path, such as security.capability, in header.
+func recordXattrs(path string, header *tar.Header) error {
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	if len(xattrs) == 0 {
+		return nil
+	}
+
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string)
+	}
+	for name, value := range xattrs {
+		header.PAXRecords[xattrPaxPrefix+name] = value
+	}
+	header.Format = tar.FormatPAX
+
+	return nil
+}
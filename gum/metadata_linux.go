@@ -0,0 +1,48 @@
+package gum
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"strings"
+)
+
+// setXattr sets extended attribute of path without following symlinks.
+func setXattr(path, name, value string) error {
+	return unix.Lsetxattr(path, name, []byte(value), 0)
+}
+
+// readXattrs returns extended attributes of path without following symlinks.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, size)
+	size, err = unix.Llistxattr(path, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimRight(string(buffer[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		valueSize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(path, name, value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = string(value[:valueSize])
+	}
+
+	return xattrs, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package gum
+
+import "errors"
+
+// setXattr reports that extended attributes cannot be restored on systems other than Linux.
+func setXattr(path, name, value string) error {
+	return errors.New("restoring extended attributes is supported only on Linux")
+}
+
+// readXattrs returns no extended attributes on systems other than Linux.
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,336 @@
+package gum
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	rootUserName      = "root"
+	ownershipFileName = "ownership"
+	recursiveFlag     = "-R"
+	recursiveLongArg  = "--recursive"
+	endOfOptionsArg   = "--"
+
+	passwdFile = "etc/passwd"
+	groupFile  = "etc/group"
+
+	// ownershipRecordingPrelude replaces chown and chgrp in build script with functions recording
+	// intended ownership, so it can be applied to archive even if the builder is not allowed to set it.
+	// Functions are exported, so bash scripts started by the build script record their calls as well.
+	// Failures caused only by missing permission, or by ids not mapped into the build sandbox, are ignored,
+	// other failures are reported.
+	ownershipRecordingPrelude = `__gumshield_record() { printf '%s\0' "$#" "$PWD" "$@" >> "$` + OwnershipFileEnvVarName + `"; }
+__gumshield_run() {
+	local errors status line failed=
+	{ errors=$(LC_ALL=C command "$@" 2>&1 1>&3); } 3>&1
+	status=$?
+	[ "$status" -eq 0 ] && return 0
+	while IFS= read -r line; do
+		case "$line" in
+		*"Operation not permitted"* | *"Invalid argument"*) ;;
+		*) printf '%s\n' "$line" >&2; failed=1 ;;
+		esac
+	done <<< "$errors"
+	[ -z "$failed" ] || return "$status"
+}
+chown() { __gumshield_record chown "$@"; __gumshield_run chown "$@"; }
+chgrp() { __gumshield_record chgrp "$@"; __gumshield_run chgrp "$@"; }
+export -f __gumshield_record __gumshield_run chown chgrp
+`
+)
+
+// intendedOwnership holds owner, group and mode a packaged file should be installed with.
+type intendedOwnership struct {
+	owner string
+	group string
+	mode  *int64
+}
+
+// ownershipTable maps paths relative to fake root to their intended ownership.
+type ownershipTable map[string]*intendedOwnership
+
+// newOwnershipTable collects ownership recorded from build script in record file and declared in package
+// definition. Declared entries take precedence.
+func newOwnershipTable(recordFile, fakeRootDir string, permissions []FilePermission) (ownershipTable, error) {
+	table := make(ownershipTable)
+	if err := table.addRecorded(recordFile, fakeRootDir); err != nil {
+		return nil, err
+	}
+	if err := table.addDeclared(permissions); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+func (t ownershipTable) entry(name string) *intendedOwnership {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if t[name] == nil {
+		t[name] = &intendedOwnership{}
+	}
+
+	return t[name]
+}
+
+func (t ownershipTable) addDeclared(permissions []FilePermission) error {
+	for _, permission := range permissions {
+		entry := t.entry(permission.Path)
+		if permission.Owner != "" {
+			entry.owner = permission.Owner
+		}
+		if permission.Group != "" {
+			entry.group = permission.Group
+		}
+		if permission.Mode != "" {
+			mode, err := strconv.ParseInt(permission.Mode, 8, 64)
+			if err != nil || mode < 0 || mode > 07777 {
+				return fmt.Errorf("%s: invalid mode %q", permission.Path, permission.Mode)
+			}
+			entry.mode = &mode
+		}
+	}
+
+	return nil
+}
+
+// addRecorded parses chown and chgrp calls written by ownershipRecordingPrelude. Every record is a list
+// of NUL terminated fields: number of arguments, working directory and the arguments.
+func (t ownershipTable) addRecorded(recordFile, fakeRootDir string) error {
+	content, err := os.ReadFile(recordFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(content), "\x00"), "\x00")
+	for len(fields) >= 2 {
+		count, err := strconv.Atoi(fields[0])
+		if err != nil || count < 0 || len(fields) < count+2 {
+			return fmt.Errorf("corrupted ownership record in %s", recordFile)
+		}
+		workDir, args := fields[1], fields[2:count+2]
+		fields = fields[count+2:]
+
+		if err := t.addRecordedCall(workDir, args, fakeRootDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t ownershipTable) addRecordedCall(workDir string, args []string, fakeRootDir string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	command, args := args[0], args[1:]
+
+	recursive := false
+	operands := make([]string, 0)
+	for i, arg := range args {
+		if arg == endOfOptionsArg {
+			operands = append(operands, args[i+1:]...)
+			break
+		}
+		if arg == recursiveFlag || arg == recursiveLongArg || (strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "R")) {
+			recursive = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		operands = append(operands, arg)
+	}
+	if len(operands) < 2 {
+		return nil
+	}
+
+	owner, group := "", operands[0]
+	if command == "chown" {
+		owner, group = operands[0], ""
+		// dot separates group only in the old owner.group form, names given with colon may contain dots
+		separator := ":"
+		if !strings.Contains(operands[0], separator) {
+			separator = "."
+		}
+		if i := strings.Index(operands[0], separator); i >= 0 {
+			owner, group = operands[0][:i], operands[0][i+1:]
+		}
+	}
+
+	for _, file := range operands[1:] {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(workDir, file)
+		}
+		err := t.setOwner(file, owner, group, fakeRootDir, recursive)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t ownershipTable) setOwner(file, owner, group, fakeRootDir string, recursive bool) error {
+	set := func(file string) {
+		relative, err := filepath.Rel(fakeRootDir, file)
+		if err != nil || relative == ".." || strings.HasPrefix(relative, "../") {
+			return
+		}
+		entry := t.entry(relative)
+		if owner != "" {
+			entry.owner = owner
+		}
+		if group != "" {
+			entry.group = group
+		}
+	}
+
+	if !recursive {
+		set(file)
+		return nil
+	}
+
+	return filepath.Walk(file, func(path string, info fs.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		set(path)
+		return nil
+	})
+}
+
+// apply sets ownership of archive entry. Files owned by the builder become owned by root, recorded
+// and declared ownership and modes replace what was found on disk.
+func (t ownershipTable) apply(header *tar.Header) error {
+	if header.Uid == os.Getuid() {
+		header.Uid, header.Uname = 0, rootUserName
+	}
+	if header.Gid == os.Getgid() {
+		header.Gid, header.Gname = 0, rootUserName
+	}
+
+	entry, ok := t[strings.TrimPrefix(path.Clean("/"+header.Name), "/")]
+	if !ok {
+		return nil
+	}
+	if entry.owner != "" {
+		uid, err := lookupUserID(entry.owner)
+		if err != nil {
+			return err
+		}
+		header.Uid, header.Uname = uid, entry.owner
+		if _, err := strconv.Atoi(entry.owner); err == nil {
+			header.Uname = ""
+		}
+	}
+	if entry.group != "" {
+		gid, err := lookupGroupID(entry.group)
+		if err != nil {
+			return err
+		}
+		header.Gid, header.Gname = gid, entry.group
+		if _, err := strconv.Atoi(entry.group); err == nil {
+			header.Gname = ""
+		}
+	}
+	if entry.mode != nil {
+		header.Mode = header.Mode&^07777 | *entry.mode
+	}
+
+	return nil
+}
+
+// lookupUserID resolves user name or number on the build host. Names unknown on the build host are refused,
+// so a typo does not leave files owned by root.
+func lookupUserID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, fmt.Errorf("owner %q: %w, create the user on the build host or give numeric id", name, err)
+	}
+
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGroupID resolves group name or number on the build host, like lookupUserID.
+func lookupGroupID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("group %q: %w, create the group on the build host or give numeric id", name, err)
+	}
+
+	return strconv.Atoi(g.Gid)
+}
+
+// idMap holds user and group ids of system installed in root, read from its passwd and group files.
+type idMap struct {
+	users  map[string]int
+	groups map[string]int
+}
+
+func loadIDMap(root string) *idMap {
+	return &idMap{
+		users:  readIDFile(filepath.Join(root, passwdFile)),
+		groups: readIDFile(filepath.Join(root, groupFile)),
+	}
+}
+
+// readIDFile maps names to ids from file in passwd or group format, missing file gives empty map.
+func readIDFile(filePath string) map[string]int {
+	ids := make(map[string]int)
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return ids
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if id, err := strconv.Atoi(fields[2]); err == nil {
+			ids[fields[0]] = id
+		}
+	}
+
+	return ids
+}
+
+// owner returns uid and gid of archive entry, preferring names known in the target system over stored ids.
+// Without map, stored ids are returned.
+func (m *idMap) owner(header *tar.Header) (int, int) {
+	uid, gid := header.Uid, header.Gid
+	if m == nil {
+		return uid, gid
+	}
+	if id, ok := m.users[header.Uname]; ok && header.Uname != "" {
+		uid = id
+	}
+	if id, ok := m.groups[header.Gname]; ok && header.Gname != "" {
+		gid = id
+	}
+
+	return uid, gid
+}
@@ -0,0 +1,50 @@
+package gum
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddRecordedCorrupted(t *testing.T) {
+	for _, content := range []string{
+		"x\x00/\x00chown\x00",
+		"-1\x00/\x00chown\x00",
+		"5\x00/\x00chown\x00root\x00",
+	} {
+		recordFile := filepath.Join(t.TempDir(), ownershipFileName)
+		if err := os.WriteFile(recordFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := make(ownershipTable).addRecorded(recordFile, "/")
+		if err == nil || !strings.Contains(err.Error(), "corrupted") {
+			t.Errorf("%q: expected corrupted record, got %v", content, err)
+		}
+	}
+}
+
+func TestAddRecordedCallOwnerGroup(t *testing.T) {
+	tests := []struct {
+		args  []string
+		owner string
+		group string
+	}{
+		{[]string{"chown", "first.last:grp", "file"}, "first.last", "grp"},
+		{[]string{"chown", "first.last:", "file"}, "first.last", ""},
+		{[]string{"chown", "user.grp", "file"}, "user", "grp"},
+		{[]string{"chown", "user", "file"}, "user", ""},
+		{[]string{"chown", ":grp", "file"}, "", "grp"},
+		{[]string{"chgrp", "my.group", "file"}, "", "my.group"},
+	}
+	for _, test := range tests {
+		table := make(ownershipTable)
+		if err := table.addRecordedCall("/fake", test.args, "/fake"); err != nil {
+			t.Fatal(err)
+		}
+		entry := table["file"]
+		if entry == nil || entry.owner != test.owner || entry.group != test.group {
+			t.Errorf("%v: got %+v, expected owner %q group %q", test.args, entry, test.owner, test.group)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package gum
+
+import (
+	"path"
+	"path/filepath"
+)
+
+// FileOwner is package containing file, with path of the file as recorded in the package.
+type FileOwner struct {
+	Path    string
+	Package string
+}
+
+// Owns finds packages containing file at path of system installed in root. Path is looked up as given,
+// with symlinks in its parent directories resolved and with every symlink resolved, so files reached
+// through links such as /lib pointing to /usr/lib are found as well. Symlinks are resolved within root.
+func Owns(filePath, root string) ([]FileOwner, error) {
+	db, err := openDatabase(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// relative paths are relative to working directory only when managing the running system
+	if !filepath.IsAbs(filePath) && filepath.Clean(root) == RootDir {
+		if filePath, err = filepath.Abs(filePath); err != nil {
+			return nil, err
+		}
+	}
+	literal := path.Clean(RootDir + filePath)
+	candidates := []string{literal}
+	for _, resolve := range []func(string, string) (string, error){resolveInRoot, resolveDirInRoot} {
+		hostPath, err := resolve(root, literal)
+		if err != nil {
+			return nil, err
+		}
+		resolved, err := pathInRoot(root, hostPath)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, resolved)
+	}
+
+	owners := make([]FileOwner, 0)
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		for _, name := range db.owners(candidate) {
+			owners = append(owners, FileOwner{Path: candidate, Package: name})
+		}
+	}
+
+	return owners, nil
+}
+
+// pathInRoot converts path on host to absolute path inside root.
+func pathInRoot(root, hostPath string) (string, error) {
+	relative, err := filepath.Rel(root, hostPath)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Clean(RootDir + filepath.ToSlash(relative)), nil
+}
@@ -3,10 +3,11 @@ package gum
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"gopkg.in/yaml.v3"
-	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -18,12 +19,17 @@ const (
 	beforeInstallSectionTag = "%%% BEFORE INSTALL"
 	afterInstallSectionTag  = "%%% AFTER INSTALL"
 	uninstallSectionTag     = "%%% UNINSTALL"
+	beforeUpgradeSectionTag = "%%% BEFORE UPGRADE"
+	afterUpgradeSectionTag  = "%%% AFTER UPGRADE"
 	buildSectionTag         = "%%% BUILD"
 	filesSectionTag         = "%%% FILES"
 	tagLikeTerminator       = "%%%"
+
+	fileEntrySeparator  = "\t"
+	emptyFileEntryField = "-"
 )
 
-func NewPackageDefinition(name, version string, sources []string, description, buildLogic, beforeInstallLogic, afterInstallLogic, uninstallLogic string, files []string) *PackageDefinition {
+func NewPackageDefinition(name, version string, sources []Source, description, buildLogic, beforeInstallLogic, afterInstallLogic, uninstallLogic string, files []string) *PackageDefinition {
 	return &PackageDefinition{
 		Name:               name,
 		Version:            version,
@@ -53,6 +59,8 @@ func ParsePackageDefinition(content string) (*PackageDefinition, error) {
 		beforeInstallSectionTag: {},
 		afterInstallSectionTag:  {},
 		uninstallSectionTag:     {},
+		beforeUpgradeSectionTag: {},
+		afterUpgradeSectionTag:  {},
 		buildSectionTag:         {},
 		filesSectionTag:         {},
 	}
@@ -76,13 +84,19 @@ func ParsePackageDefinition(content string) (*PackageDefinition, error) {
 	beforeInstallLogic := strings.Join(sections[beforeInstallSectionTag], "\n")
 	afterInstallLogic := strings.Join(sections[afterInstallSectionTag], "\n")
 	uninstallLogic := strings.Join(sections[uninstallSectionTag], "\n")
-	files := sections[filesSectionTag]
+	files, fileEntries, err := parseFilesSection(sections[filesSectionTag])
+	if err != nil {
+		return nil, err
+	}
 	metadata, err := getMetadata(strings.Join(sections[metaSectionTag], "\n"))
 	if err != nil {
 		return nil, err
 	}
+	if err := validateMetadata(metadata); err != nil {
+		return nil, err
+	}
 
-	return NewPackageDefinition(
+	pkg := NewPackageDefinition(
 		metadata.Name,
 		metadata.Version,
 		metadata.Sources,
@@ -92,16 +106,68 @@ func ParsePackageDefinition(content string) (*PackageDefinition, error) {
 		afterInstallLogic,
 		uninstallLogic,
 		files,
-	), nil
+	)
+	pkg.BeforeUpgradeLogic = strings.Join(sections[beforeUpgradeSectionTag], "\n")
+	pkg.AfterUpgradeLogic = strings.Join(sections[afterUpgradeSectionTag], "\n")
+	pkg.Depends = metadata.Depends
+	pkg.BuildDepends = metadata.BuildDepends
+	pkg.OptionalDepends = metadata.OptionalDepends
+	pkg.Permissions = metadata.Permissions
+	pkg.FileEntries = fileEntries
+
+	return pkg, nil
+}
+
+// parseFilesSection reads paths of package files and, when recorded, their mode, size and checksum.
+// Entries are lines of tab separated fields, older archives list only paths.
+func parseFilesSection(lines []string) ([]string, []PackageFile, error) {
+	files := make([]string, 0, len(lines))
+	entries := make([]PackageFile, 0)
+	for _, line := range lines {
+		fields := strings.Split(line, fileEntrySeparator)
+		files = append(files, fields[0])
+		if len(fields) == 1 {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, nil, fmt.Errorf("invalid file entry %q", line)
+		}
+
+		entry := PackageFile{Path: fields[0]}
+		mode, err := strconv.ParseInt(fields[1], 8, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid mode of %s: %w", fields[0], err)
+		}
+		entry.Mode = mode
+		if fields[2] != emptyFileEntryField {
+			if entry.Size, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				return nil, nil, fmt.Errorf("invalid size of %s: %w", fields[0], err)
+			}
+		}
+		if fields[3] != emptyFileEntryField {
+			entry.Sha256 = fields[3]
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		entries = nil
+	}
+
+	return files, entries, nil
+}
+
+// formatFileEntry converts package file to FILES section line.
+func formatFileEntry(entry PackageFile) string {
+	size, checksum := emptyFileEntryField, emptyFileEntryField
+	if entry.Sha256 != "" {
+		size, checksum = strconv.FormatInt(entry.Size, 10), entry.Sha256
+	}
+
+	return strings.Join([]string{entry.Path, fmt.Sprintf("%04o", entry.Mode), size, checksum}, fileEntrySeparator)
 }
 
 func SerializePackageDefinition(pkg *PackageDefinition) (string, error) {
 	sb := strings.Builder{}
-	meta := PackageMetadata{
-		Name:    pkg.Name,
-		Version: pkg.Version,
-		Sources: pkg.Sources,
-	}
 
 	if pkg.Description != "" {
 		sb.Write([]byte(descriptionSectionTag))
@@ -112,7 +178,7 @@ func SerializePackageDefinition(pkg *PackageDefinition) (string, error) {
 
 	sb.Write([]byte(metaSectionTag))
 	sb.Write([]byte("\n"))
-	metaYaml, err := yaml.Marshal(meta)
+	metaYaml, err := serializeMetadata(pkg)
 	if err != nil {
 		return "", err
 	}
@@ -139,7 +205,30 @@ func SerializePackageDefinition(pkg *PackageDefinition) (string, error) {
 	sb.Write([]byte(pkg.UninstallLogic))
 	sb.Write([]byte("\n"))
 
-	if pkg.Files != nil && len(pkg.Files) > 0 {
+	if pkg.BeforeUpgradeLogic != "" {
+		sb.Write([]byte(beforeUpgradeSectionTag))
+		sb.Write([]byte("\n"))
+		sb.Write([]byte(pkg.BeforeUpgradeLogic))
+		sb.Write([]byte("\n"))
+	}
+
+	if pkg.AfterUpgradeLogic != "" {
+		sb.Write([]byte(afterUpgradeSectionTag))
+		sb.Write([]byte("\n"))
+		sb.Write([]byte(pkg.AfterUpgradeLogic))
+		sb.Write([]byte("\n"))
+	}
+
+	if len(pkg.FileEntries) > 0 {
+		lines := make([]string, 0, len(pkg.FileEntries))
+		for _, entry := range pkg.FileEntries {
+			lines = append(lines, formatFileEntry(entry))
+		}
+		sb.Write([]byte(filesSectionTag))
+		sb.Write([]byte("\n"))
+		sb.Write([]byte(strings.Join(lines, "\n")))
+		sb.Write([]byte("\n"))
+	} else if pkg.Files != nil && len(pkg.Files) > 0 {
 		sb.Write([]byte(filesSectionTag))
 		sb.Write([]byte("\n"))
 		sb.Write([]byte(strings.Join(pkg.Files, "\n")))
@@ -149,93 +238,154 @@ func SerializePackageDefinition(pkg *PackageDefinition) (string, error) {
 	return sb.String(), nil
 }
 
-func ValidateInstalledDefinition(pkg *PackageDefinition) error {
-	if pkg.BeforeInstallLogic == "" {
-		return errors.New("missing before install script")
-	}
-	if pkg.AfterInstallLogic == "" {
-		return errors.New("missing after install script")
-	}
-	if pkg.UninstallLogic == "" {
-		return errors.New("missing uninstall script")
-	}
-	if pkg.Files == nil || len(pkg.Files) == 0 {
-		return errors.New("missing file list")
+func serializeMetadata(pkg *PackageDefinition) ([]byte, error) {
+	meta := PackageMetadata{
+		Name:            pkg.Name,
+		Version:         pkg.Version,
+		Sources:         pkg.Sources,
+		Depends:         pkg.Depends,
+		BuildDepends:    pkg.BuildDepends,
+		OptionalDepends: pkg.OptionalDepends,
+		Permissions:     pkg.Permissions,
 	}
 
-	return nil
+	return yaml.Marshal(meta)
 }
 
-func readPackagesFromIndex() ([]*PackageDefinition, error) {
-	files, err := ioutil.ReadDir(DefaultIndexDir)
+// updateSourceChecksums writes checksums of sources into META section of definition content. Only checksum
+// values of sources are replaced, missing checksum keys are added after the last key of their source and plain
+// url sources become mappings, so comments, key order and indentation of the section are kept.
+func updateSourceChecksums(content string, sources []Source) (string, error) {
+	lines := strings.Split(content, "\n")
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		if !strings.HasPrefix(line, tagLikeTerminator) {
+			continue
+		}
+		if start >= 0 {
+			end = i
+			break
+		}
+		if strings.HasPrefix(line, metaSectionTag) {
+			start = i + 1
+		}
+	}
+	if start < 0 {
+		return "", errors.New("missing META section")
+	}
+
+	document := yaml.Node{}
+	if err := yaml.Unmarshal([]byte(strings.Join(lines[start:end], "\n")), &document); err != nil {
+		return "", err
+	}
+	items, err := sourceNodes(&document)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if len(items) != len(sources) {
+		return "", fmt.Errorf("META section lists %d sources, expected %d", len(items), len(sources))
 	}
 
-	packages := make([]*PackageDefinition, 0)
-	for _, file := range files {
-		filePath := path.Join(DefaultIndexDir, file.Name())
-		content, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			return nil, err
+	// node lines are counted from 1 within the section, added lines are collected and inserted last,
+	// so line numbers stay valid while values are replaced
+	added := make(map[int][]string)
+	for i, item := range items {
+		missing := map[string]bool{sha256Algorithm: true, sha512Algorithm: true, b2Algorithm: true}
+		if item.Kind == yaml.ScalarNode {
+			line := start + item.Line - 1
+			column := item.Column - 1
+			lines[line] = lines[line][:column] + "url: " + lines[line][column:]
+			item = &yaml.Node{Kind: yaml.MappingNode, Line: item.Line, Column: item.Column,
+				Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: "url", Line: item.Line, Column: item.Column}, item}}
 		}
-		pkg, err := ParsePackageDefinition(string(content))
-		if err != nil {
-			return nil, err
+		if item.Kind != yaml.MappingNode || item.Style&yaml.FlowStyle != 0 {
+			return "", fmt.Errorf("line %d: checksums can be updated only for sources in block style", item.Line)
 		}
 
-		packages = append(packages, pkg)
-	}
-
-	return packages, nil
-}
+		last := item.Line
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			key, value := item.Content[j], item.Content[j+1]
+			if value.Line > last {
+				last = value.Line
+			}
+			checksum := sources[i].checksum(key.Value)
+			if !missing[key.Value] || checksum == "" {
+				continue
+			}
+			delete(missing, key.Value)
+			line := start + value.Line - 1
+			if value.Tag == "!!null" && value.Value == "" {
+				// key without value, e.g. "sha256:"
+				line = start + key.Line - 1
+				lines[line] = strings.TrimRight(lines[line], " ") + " " + checksum
+				continue
+			}
+			column, length := value.Column-1, len(value.Value)
+			if value.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+				column++
+			}
+			lines[line] = lines[line][:column] + checksum + lines[line][column+length:]
+		}
 
-func getPackageFromIndex(packageName string) (*PackageDefinition, error) {
-	packages, err := readPackagesFromIndex()
-	if err != nil {
-		return nil, err
-	}
-	for _, pkg := range packages {
-		if pkg.Name == packageName {
-			return pkg, nil
+		indent := strings.Repeat(" ", item.Column-1)
+		for _, algorithm := range []string{sha256Algorithm, sha512Algorithm, b2Algorithm} {
+			if checksum := sources[i].checksum(algorithm); missing[algorithm] && checksum != "" {
+				added[start+last-1] = append(added[start+last-1], indent+algorithm+": "+checksum)
+			}
 		}
 	}
 
-	return nil, errors.New("no such package")
+	updated := make([]string, 0, len(lines))
+	for i, line := range lines {
+		updated = append(updated, line)
+		updated = append(updated, added[i]...)
+	}
+
+	return strings.Join(updated, "\n"), nil
 }
 
-func isInstalled(packageName string) error {
-	packages, err := readPackagesFromIndex()
-	if err != nil {
-		return err
+// sourceNodes returns nodes of items of sources list in parsed META section.
+func sourceNodes(document *yaml.Node) ([]*yaml.Node, error) {
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("META section is not a mapping")
 	}
-
-	for _, pkg := range packages {
-		if pkg.Name == packageName {
-			return errors.New("package already installed")
+	meta := document.Content[0]
+	for i := 0; i+1 < len(meta.Content); i += 2 {
+		if meta.Content[i].Value != "sources" {
+			continue
 		}
+		list := meta.Content[i+1]
+		if list.Kind != yaml.SequenceNode || list.Style&yaml.FlowStyle != 0 {
+			return nil, fmt.Errorf("line %d: checksums can be updated only for sources in block style", list.Line)
+		}
+		return list.Content, nil
 	}
 
-	return nil
+	return nil, nil
 }
 
-// TODO: move adding to package index to separete function
-
-func removePackageFromIndex(pkgName string) error {
-	filePath := path.Join(DefaultIndexDir, pkgName+DefinitionFileExtension)
-	_, err := os.Stat(filePath)
-	if err != nil {
-		return err
+func ValidateInstalledDefinition(pkg *PackageDefinition) error {
+	if pkg.BeforeInstallLogic == "" {
+		return errors.New("missing before install script")
 	}
-
-	err = os.Remove(filePath)
-	if err != nil {
-		return err
+	if pkg.AfterInstallLogic == "" {
+		return errors.New("missing after install script")
+	}
+	if pkg.UninstallLogic == "" {
+		return errors.New("missing uninstall script")
+	}
+	if pkg.Files == nil || len(pkg.Files) == 0 {
+		return errors.New("missing file list")
 	}
 
 	return nil
 }
 
+// indexDir returns path of package index of system installed in root.
+func indexDir(root string) string {
+	return filepath.Join(root, currentConfig().IndexDir)
+}
+
 func setSectionTag(currentSection *string, line string) bool {
 	if strings.HasPrefix(line, descriptionSectionTag) {
 		*currentSection = descriptionSectionTag
@@ -257,6 +407,14 @@ func setSectionTag(currentSection *string, line string) bool {
 		*currentSection = uninstallSectionTag
 		return true
 	}
+	if strings.HasPrefix(line, beforeUpgradeSectionTag) {
+		*currentSection = beforeUpgradeSectionTag
+		return true
+	}
+	if strings.HasPrefix(line, afterUpgradeSectionTag) {
+		*currentSection = afterUpgradeSectionTag
+		return true
+	}
 	if strings.HasPrefix(line, buildSectionTag) {
 		*currentSection = buildSectionTag
 		return true
@@ -288,3 +446,31 @@ func getMetadata(yamlContent string) (PackageMetadata, error) {
 
 	return metadata, err
 }
+
+func validateMetadata(metadata PackageMetadata) error {
+	if err := validatePackageName(metadata.Name); err != nil {
+		return err
+	}
+	if metadata.Version != "" {
+		if _, err := ParseVersion(metadata.Version); err != nil {
+			return err
+		}
+	}
+	for _, dependencies := range [][]string{metadata.Depends, metadata.BuildDepends, metadata.OptionalDepends} {
+		if _, err := parseDependencies(dependencies); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validatePackageName rejects names that cannot be used as a single path element, as package name is part of
+// paths of its logs and work directories. Names starting with dot are kept for files of gumshield itself.
+func validatePackageName(name string) error {
+	if strings.ContainsAny(name, "/\x00") || strings.Contains(name, "..") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid package name %q", name)
+	}
+
+	return nil
+}
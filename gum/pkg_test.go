@@ -0,0 +1,80 @@
+package gum
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePackageDefinitionName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"curl", true},
+		{"libfoo-1.2", true},
+		{"a/b", false},
+		{"..", false},
+		{".", false},
+		{".hidden", false},
+		{"../../etc/cron.d/x", false},
+		{"a..b", false},
+		{"a\x00b", false},
+	}
+	for _, test := range tests {
+		definition := "%%% META\nname: \"" + strings.ReplaceAll(test.name, "\x00", `\0`) + "\"\nversion: \"1.0\"\n%%% BUILD\ntrue\n"
+		pkg, err := ParsePackageDefinition(definition)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%q: %v", test.name, err)
+		case test.valid && pkg.Name != test.name:
+			t.Errorf("%q: parsed name %q", test.name, pkg.Name)
+		case !test.valid && (err == nil || !strings.Contains(err.Error(), "invalid package name")):
+			t.Errorf("%q: expected invalid package name, got %v", test.name, err)
+		}
+	}
+}
+
+func TestUpdateSourceChecksums(t *testing.T) {
+	content := `%%% META
+name: tool
+# fetched from upstream
+sources:
+    - https://example.com/tool.tar.gz
+    -   url: https://example.com/patch.diff # local fix
+        sha256: "old"
+        b2: stale
+    - url: https://example.com/data.tar.gz
+      sha512:
+%%% BUILD
+echo sources: []
+`
+	expected := `%%% META
+name: tool
+# fetched from upstream
+sources:
+    - url: https://example.com/tool.tar.gz
+      sha256: aaa
+    -   url: https://example.com/patch.diff # local fix
+        sha256: "bbb"
+        b2: ccc
+    - url: https://example.com/data.tar.gz
+      sha512: ddd
+%%% BUILD
+echo sources: []
+`
+	updated, err := updateSourceChecksums(content, []Source{
+		{Url: "https://example.com/tool.tar.gz", Sha256: "aaa"},
+		{Url: "https://example.com/patch.diff", Sha256: "bbb", B2: "ccc"},
+		{Url: "https://example.com/data.tar.gz", Sha512: "ddd"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != expected {
+		t.Errorf("updated definition:\n%s\nexpected:\n%s", updated, expected)
+	}
+
+	if _, err := updateSourceChecksums("%%% META\nsources: [https://example.com/a.tar.gz]\n", []Source{{Sha256: "aaa"}}); err == nil {
+		t.Error("expected error for sources in flow style")
+	}
+}
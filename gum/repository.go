@@ -0,0 +1,343 @@
+package gum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// RepositoryIndexSchemaVersion is version of repository index layout written by repo-add.
+	RepositoryIndexSchemaVersion = 1
+
+	fileURLScheme  = "file"
+	httpURLScheme  = "http"
+	httpsURLScheme = "https"
+
+	partialDownloadSuffix = ".part"
+)
+
+// RepositoryIndex lists packages available in repository.
+type RepositoryIndex struct {
+	Schema   int                 `json:"schema"`
+	Packages []RepositoryPackage `json:"packages"`
+}
+
+// RepositoryPackage describes package archive stored in repository, File is relative to repository url.
+type RepositoryPackage struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Description string   `json:"description,omitempty"`
+	Depends     []string `json:"depends,omitempty"`
+	File        string   `json:"file"`
+	Size        int64    `json:"size"`
+	Sha256      string   `json:"sha256"`
+
+	repository string
+}
+
+// RepositoryAdd writes index of every package archive found in directory into its index file.
+// Returns number of indexed packages.
+func RepositoryAdd(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	index := RepositoryIndex{Schema: RepositoryIndexSchemaVersion, Packages: make([]RepositoryPackage, 0)}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isPackageArchiveName(entry.Name()) {
+			continue
+		}
+		archivePath := filepath.Join(dir, entry.Name())
+		pkg, _, err := readArchiveDefinition(archivePath)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", archivePath, err)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		checksum, err := fileDigest(archivePath, sha256Algorithm)
+		if err != nil {
+			return 0, err
+		}
+
+		index.Packages = append(index.Packages, RepositoryPackage{
+			Name:        pkg.Name,
+			Version:     pkg.Version,
+			Description: pkg.Description,
+			Depends:     pkg.Depends,
+			File:        entry.Name(),
+			Size:        info.Size(),
+			Sha256:      checksum,
+		})
+	}
+	sort.Slice(index.Packages, func(i, j int) bool {
+		a, b := index.Packages[i], index.Packages[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.File < b.File
+	})
+
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	indexPath := filepath.Join(dir, RepositoryIndexFileName)
+	tempPath := indexPath + stagedFileSuffix
+	if err := os.WriteFile(tempPath, append(content, '\n'), 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tempPath, indexPath); err != nil {
+		_ = os.Remove(tempPath)
+		return 0, err
+	}
+
+	return len(index.Packages), nil
+}
+
+// IsRepositoryPackageName checks if argument names package from repository rather than archive file, that is
+// it has neither path separator nor archive extension.
+func IsRepositoryPackageName(name string) bool {
+	return !strings.ContainsRune(name, '/') && !isPackageArchiveName(name)
+}
+
+// isPackageArchiveName checks if file name has extension of package archive compressed with any codec.
+func isPackageArchiveName(name string) bool {
+	for _, codec := range CompressionCodecs {
+		if strings.HasSuffix(name, ArchiveFileExtension+CompressionExtension(codec)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetchRepositoryIndexes reads index of every repository.
+func fetchRepositoryIndexes(repositories []string) ([]RepositoryIndex, error) {
+	indexes := make([]RepositoryIndex, 0, len(repositories))
+	for _, repository := range repositories {
+		reader, err := openRepositoryFile(repository, RepositoryIndexFileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", repository, err)
+		}
+		index := RepositoryIndex{}
+		err = json.NewDecoder(reader).Decode(&index)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", repository, err)
+		}
+		if index.Schema > RepositoryIndexSchemaVersion {
+			return nil, fmt.Errorf("%s: index schema %d is newer than supported %d", repository, index.Schema, RepositoryIndexSchemaVersion)
+		}
+
+		for i := range index.Packages {
+			index.Packages[i].repository = repository
+		}
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// openRepositoryFile opens file stored in repository at file:// or http(s):// url.
+func openRepositoryFile(repository, name string) (io.ReadCloser, error) {
+	u, err := url.Parse(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case fileURLScheme:
+		return os.Open(filepath.Join(u.Path, filepath.FromSlash(name)))
+	case httpURLScheme, httpsURLScheme:
+		u.Path = path.Join(u.Path, name)
+		resp, err := http.Get(u.String())
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: bad status: %s", u, resp.Status)
+		}
+		return resp.Body, nil
+	}
+
+	return nil, fmt.Errorf("unsupported repository url scheme %q", u.Scheme)
+}
+
+// findRepositoryPackage returns highest version of package satisfying dependency from the first repository
+// providing it.
+func findRepositoryPackage(indexes []RepositoryIndex, dependency Dependency) (*RepositoryPackage, error) {
+	for _, index := range indexes {
+		var found *RepositoryPackage
+		for i, pkg := range index.Packages {
+			if pkg.Name != dependency.Name || !dependency.SatisfiedBy(pkg.Version) {
+				continue
+			}
+			if found != nil {
+				if result, err := CompareVersions(pkg.Version, found.Version); err != nil || result <= 0 {
+					continue
+				}
+			}
+			found = &index.Packages[i]
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no package satisfying %s found in repositories", dependency.String())
+}
+
+// fetchRepositoryPackage returns path of package archive in cache directory, downloading it when
+// it is not cached yet. Archives not matching checksum from repository index are refused.
+func fetchRepositoryPackage(pkg *RepositoryPackage, cacheDir string) (string, error) {
+	cachePath := filepath.Join(cacheDir, path.Base(pkg.File))
+	if checksum, err := fileDigest(cachePath, sha256Algorithm); err == nil && checksum == pkg.Sha256 {
+		return cachePath, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	reader, err := openRepositoryFile(pkg.repository, pkg.File)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	partialPath := cachePath + partialDownloadSuffix
+	out, err := os.Create(partialPath)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, reader)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partialPath)
+		return "", err
+	}
+
+	checksum, err := fileDigest(partialPath, sha256Algorithm)
+	if err != nil {
+		_ = os.Remove(partialPath)
+		return "", err
+	}
+	if checksum != pkg.Sha256 {
+		_ = os.Remove(partialPath)
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s:%s, got %s:%s", pkg.File, sha256Algorithm, pkg.Sha256, sha256Algorithm, checksum)
+	}
+
+	return cachePath, os.Rename(partialPath, cachePath)
+}
+
+// installStep is package fetched from repository and installed with reason.
+type installStep struct {
+	pkg    *RepositoryPackage
+	reason string
+}
+
+// resolveInstallPlan orders package satisfying requested dependency after every missing dependency it
+// needs, each of them found in repositories. Dependencies installed in acceptable version are skipped.
+func resolveInstallPlan(request Dependency, indexes []RepositoryIndex, db *packageDatabase) ([]installStep, error) {
+	plan := make([]installStep, 0)
+	planned := make(map[string]bool)
+	visiting := make(map[string]bool)
+
+	var visit func(dependency Dependency, reason string, requiredBy string) error
+	visit = func(dependency Dependency, reason string, requiredBy string) error {
+		if installed, ok := db.Packages[dependency.Name]; ok && reason == InstallReasonDependency {
+			if dependency.SatisfiedBy(installed.Version) {
+				return nil
+			}
+			return fmt.Errorf("%s requires %s, installed %s, upgrade it first", requiredBy, dependency.String(), installed.Version)
+		}
+		if planned[dependency.Name] {
+			return nil
+		}
+		if visiting[dependency.Name] {
+			return fmt.Errorf("dependency cycle through %s", dependency.Name)
+		}
+		visiting[dependency.Name] = true
+
+		pkg, err := findRepositoryPackage(indexes, dependency)
+		if err != nil {
+			return err
+		}
+		dependencies, err := parseDependencies(pkg.Depends)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+		for _, dependency := range dependencies {
+			if err := visit(dependency, InstallReasonDependency, pkg.Name); err != nil {
+				return err
+			}
+		}
+
+		visiting[dependency.Name] = false
+		planned[dependency.Name] = true
+		plan = append(plan, installStep{pkg: pkg, reason: reason})
+		return nil
+	}
+
+	if err := visit(request, InstallReasonExplicit, ""); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// InstallFromRepositories installs package matching name, optionally with version constraints
+// (e.g. openssl>=3.0), from configured repositories together with its missing dependencies.
+// Dependencies are installed first and recorded with dependency install reason.
+func InstallFromRepositories(name, root string, options InstallOptions) error {
+	if err := isElevated(); err != nil {
+		return err
+	}
+
+	request, err := ParseDependency(name)
+	if err != nil {
+		return err
+	}
+	config := currentConfig()
+	if len(config.Repositories) == 0 {
+		return errors.New("no repositories configured")
+	}
+	indexes, err := fetchRepositoryIndexes(config.Repositories)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(root)
+	if err != nil {
+		return err
+	}
+	plan, err := resolveInstallPlan(request, indexes, db)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range plan {
+		archivePath, err := fetchRepositoryPackage(step.pkg, config.CacheDir)
+		if err != nil {
+			return err
+		}
+		if err := installArchive(archivePath, root, step.reason, options); err != nil {
+			return fmt.Errorf("%s: %w", step.pkg.Name, err)
+		}
+	}
+
+	return nil
+}
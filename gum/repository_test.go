@@ -0,0 +1,258 @@
+package gum
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingServer serves directory over http and counts requests of every path.
+type countingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newCountingServer(t *testing.T, dir string) *countingServer {
+	server := &countingServer{requests: make(map[string]int)}
+	files := http.FileServer(http.Dir(dir))
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.requests[r.URL.Path]++
+		server.mu.Unlock()
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *countingServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// useConfig replaces configuration for the duration of test.
+func useConfig(t *testing.T, config *Config) {
+	_, _ = LoadConfig()
+	previous, previousErr := loadedConfig, loadedConfigErr
+	loadedConfig, loadedConfigErr = config, nil
+	t.Cleanup(func() {
+		loadedConfig, loadedConfigErr = previous, previousErr
+	})
+}
+
+// writeTestPackage creates package archive holding single file in dir.
+func writeTestPackage(t *testing.T, dir, name, version string, depends []string) string {
+	fakeRoot, tempDir := t.TempDir(), t.TempDir()
+	filePath := filepath.Join(fakeRoot, "usr", "share", name, "file")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(name+" "+version+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &PackageDefinition{
+		Name:               name,
+		Version:            version,
+		Depends:            depends,
+		BeforeInstallLogic: "true",
+		AfterInstallLogic:  "true",
+		UninstallLogic:     "true",
+	}
+	archivePath := filepath.Join(dir, name+ArchiveFileExtension+CompressionExtension(CompressionZstd))
+	if err := createPackageArchive(fakeRoot, tempDir, archivePath, pkg, nil, archiveOptions{compression: CompressionZstd}); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+// newRepositoryTest creates repository with lib and app depending on it, served over http, and configures
+// gumshield to use it.
+func newRepositoryTest(t *testing.T) (string, *countingServer, *Config) {
+	if os.Geteuid() != 0 {
+		t.Skip("installing packages requires root")
+	}
+	repoDir := t.TempDir()
+	writeTestPackage(t, repoDir, "lib", "1.0", nil)
+	writeTestPackage(t, repoDir, "app", "2.1", []string{"lib>=1.0"})
+
+	count, err := RepositoryAdd(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("indexed %d packages, expected 2", count)
+	}
+
+	server := newCountingServer(t, repoDir)
+	config := DefaultConfig()
+	config.Repositories = []string{server.URL}
+	config.CacheDir = t.TempDir()
+	config.TempDir = t.TempDir()
+	config.KeyringDir = t.TempDir()
+	config.LogDir = t.TempDir()
+	useConfig(t, config)
+
+	return repoDir, server, config
+}
+
+func TestRepositoryAddWritesIndex(t *testing.T) {
+	repoDir := t.TempDir()
+	libPath := writeTestPackage(t, repoDir, "lib", "1.0", nil)
+	writeTestPackage(t, repoDir, "app", "2.1", []string{"lib>=1.0"})
+	if err := os.WriteFile(filepath.Join(repoDir, "notes.txt"), []byte("not a package"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := RepositoryAdd(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("indexed %d packages, expected 2", count)
+	}
+
+	content, err := os.ReadFile(filepath.Join(repoDir, RepositoryIndexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := RepositoryIndex{}
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Schema != RepositoryIndexSchemaVersion || len(index.Packages) != 2 {
+		t.Fatalf("unexpected index %+v", index)
+	}
+	app, lib := index.Packages[0], index.Packages[1]
+	if app.Name != "app" || app.Version != "2.1" || len(app.Depends) != 1 || app.Depends[0] != "lib>=1.0" {
+		t.Errorf("unexpected app entry %+v", app)
+	}
+	checksum, err := fileDigest(libPath, sha256Algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(libPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.Name != "lib" || lib.File != filepath.Base(libPath) || lib.Sha256 != checksum || lib.Size != info.Size() {
+		t.Errorf("unexpected lib entry %+v", lib)
+	}
+}
+
+func TestInstallFromHTTPRepository(t *testing.T) {
+	_, server, config := newRepositoryTest(t)
+	root := t.TempDir()
+
+	options := InstallOptions{AllowUnsigned: true, SkipScripts: true}
+	if err := InstallFromRepositories("app>=2", root, options); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDatabase(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, reason := range map[string]string{"app": InstallReasonExplicit, "lib": InstallReasonDependency} {
+		installed, err := db.get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if installed.Reason != reason {
+			t.Errorf("%s installed with reason %s, expected %s", name, installed.Reason, reason)
+		}
+		if _, err := os.Stat(filepath.Join(root, "usr", "share", name, "file")); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(filepath.Join(config.CacheDir, name+".tar.zst")); err != nil {
+			t.Errorf("%s not cached: %v", name, err)
+		}
+	}
+	if count := server.count("/" + RepositoryIndexFileName); count != 1 {
+		t.Errorf("index fetched %d times, expected once", count)
+	}
+}
+
+func TestRepositoryCacheReuse(t *testing.T) {
+	_, server, config := newRepositoryTest(t)
+
+	indexes, err := fetchRepositoryIndexes(config.Repositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := findRepositoryPackage(indexes, Dependency{Name: "lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := fetchRepositoryPackage(pkg, config.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := fetchRepositoryPackage(pkg, config.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("cached archive moved from %s to %s", first, second)
+	}
+	if count := server.count("/" + pkg.File); count != 1 {
+		t.Errorf("archive downloaded %d times, expected once", count)
+	}
+
+	// cached archive not matching index is downloaded again
+	if err := os.WriteFile(first, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetchRepositoryPackage(pkg, config.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+	if count := server.count("/" + pkg.File); count != 2 {
+		t.Errorf("archive downloaded %d times, expected twice", count)
+	}
+}
+
+func TestRepositoryChecksumMismatch(t *testing.T) {
+	repoDir, _, config := newRepositoryTest(t)
+
+	archive, err := os.OpenFile(filepath.Join(repoDir, "lib.tar.zst"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archive.Write([]byte("tampered")); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	err = InstallFromRepositories("app", root, InstallOptions{AllowUnsigned: true, SkipScripts: true})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	entries, err := os.ReadDir(config.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("rejected download left %s in cache", entries[0].Name())
+	}
+	db, err := openDatabase(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Packages) != 0 {
+		t.Errorf("packages installed despite checksum mismatch: %v", db.list())
+	}
+}
@@ -0,0 +1,239 @@
+package gum
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SourceDateEpochEnvVarName = "SOURCE_DATE_EPOCH"
+
+	reproducibleCheckSuffix = ".check"
+)
+
+// archiveEntry describes archive entry by fields that may differ between builds.
+type archiveEntry struct {
+	fields  map[string]string
+	present bool
+}
+
+// sourceDate returns time package timestamps are clamped to, taken from SOURCE_DATE_EPOCH.
+// Unix epoch is used when variable is not set.
+func sourceDate() (time.Time, error) {
+	value, ok := os.LookupEnv(SourceDateEpochEnvVarName)
+	if !ok || value == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: %w", SourceDateEpochEnvVarName, value, err)
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// normalizeHeader clamps modification time of entry to sourceDate and drops other timestamps.
+func normalizeHeader(header *tar.Header, sourceDate time.Time) {
+	if header.ModTime.After(sourceDate) {
+		header.ModTime = sourceDate
+	}
+	header.ModTime = header.ModTime.Truncate(time.Second)
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+}
+
+// CheckReproducible builds package twice in reproducible mode and lists entries of the two archives that differ.
+// First archive is kept in output file.
+func CheckReproducible(pkg *PackageDefinition, options BuildOptions) ([]string, error) {
+	options.Reproducible = true
+	if err := Build(pkg, options); err != nil {
+		return nil, err
+	}
+	second := options
+	second.OutputFile = options.OutputFile + reproducibleCheckSuffix
+	if err := Build(pkg, second); err != nil {
+		return nil, err
+	}
+	defer os.Remove(second.OutputFile)
+
+	return compareArchives(options.OutputFile, second.OutputFile)
+}
+
+// compareArchives lists differences between two package archives, including files of nested files archive.
+func compareArchives(firstPath, secondPath string) ([]string, error) {
+	first, err := describeArchiveEntries(firstPath)
+	if err != nil {
+		return nil, err
+	}
+	second, err := describeArchiveEntries(secondPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(first)+len(second))
+	for name := range first {
+		names = append(names, name)
+	}
+	for name := range second {
+		if _, ok := first[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	differences := make([]string, 0)
+	for _, name := range names {
+		firstEntry, secondEntry := first[name], second[name]
+		switch {
+		case !secondEntry.present:
+			differences = append(differences, fmt.Sprintf("%s: only in first build", name))
+		case !firstEntry.present:
+			differences = append(differences, fmt.Sprintf("%s: only in second build", name))
+		default:
+			if fields := differingFields(firstEntry, secondEntry); len(fields) > 0 {
+				differences = append(differences, fmt.Sprintf("%s: %s", name, strings.Join(fields, ", ")))
+			}
+		}
+	}
+	if len(differences) > 0 {
+		return differences, nil
+	}
+
+	firstContent, err := os.ReadFile(firstPath)
+	if err != nil {
+		return nil, err
+	}
+	secondContent, err := os.ReadFile(secondPath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(firstContent, secondContent) {
+		differences = append(differences, "compressed archives differ while their content matches")
+	}
+
+	return differences, nil
+}
+
+// differingFields returns sorted names of fields that differ between entries.
+func differingFields(first, second archiveEntry) []string {
+	fields := make([]string, 0)
+	for field, value := range first.fields {
+		if second.fields[field] != value {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// describeArchiveEntries reads package archive as a stream and describes its entries. Entries of files archive
+// are named after it, e.g. files.tar:usr/bin/gum.
+func describeArchiveEntries(path string) (map[string]archiveEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := newDecompressingReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := make(map[string]archiveEntry)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var content io.Reader = tarReader
+		if header.Name == FilesArchiveFileName {
+			buffer := &bytes.Buffer{}
+			content = io.TeeReader(tarReader, buffer)
+			entry, err := describeArchiveEntry(header, content)
+			if err != nil {
+				return nil, err
+			}
+			entries[header.Name] = entry
+			if err := describeNestedEntries(header.Name, buffer, entries); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		entry, err := describeArchiveEntry(header, content)
+		if err != nil {
+			return nil, err
+		}
+		entries[header.Name] = entry
+	}
+}
+
+// describeNestedEntries adds entries of tar stream to entries, prefixing their names with name of archive.
+func describeNestedEntries(archiveName string, reader io.Reader, entries map[string]archiveEntry) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, err := describeArchiveEntry(header, tarReader)
+		if err != nil {
+			return err
+		}
+		entries[archiveName+":"+header.Name] = entry
+	}
+}
+
+// describeArchiveEntry records header fields and checksum of entry content.
+func describeArchiveEntry(header *tar.Header, content io.Reader) (archiveEntry, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return archiveEntry{}, err
+	}
+
+	xattrs := make([]string, 0, len(header.PAXRecords))
+	for key, value := range header.PAXRecords {
+		xattrs = append(xattrs, key+"="+value)
+	}
+	sort.Strings(xattrs)
+
+	return archiveEntry{
+		present: true,
+		fields: map[string]string{
+			"type":     string(header.Typeflag),
+			"mode":     fmt.Sprintf("%04o", header.Mode),
+			"uid":      strconv.Itoa(header.Uid),
+			"gid":      strconv.Itoa(header.Gid),
+			"user":     header.Uname,
+			"group":    header.Gname,
+			"mtime":    header.ModTime.UTC().Format(time.RFC3339Nano),
+			"link":     header.Linkname,
+			"size":     strconv.FormatInt(header.Size, 10),
+			"content":  hex.EncodeToString(hash.Sum(nil)),
+			"pax":      strings.Join(xattrs, ";"),
+			"devmajor": strconv.FormatInt(header.Devmajor, 10),
+			"devminor": strconv.FormatInt(header.Devminor, 10),
+		},
+	}, nil
+}
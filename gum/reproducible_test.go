@@ -0,0 +1,66 @@
+package gum
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBuildTwiceReproducible builds package embedding its work directories twice and compares the archives.
+func TestBuildTwiceReproducible(t *testing.T) {
+	config := DefaultConfig()
+	config.LogDir = t.TempDir()
+	useConfig(t, config)
+
+	pkg, err := ParsePackageDefinition(`%%% META
+name: embedpath
+version: "1.0"
+%%% BUILD
+mkdir -p "$GUMSHIELD_FAKE_ROOT_DIR/usr/share/info"
+printf '%s\n' "$PWD" "$GUMSHIELD_BUILD_DIR" "$GUMSHIELD_FAKE_ROOT_DIR" "$TMPDIR" > "$GUMSHIELD_FAKE_ROOT_DIR/usr/share/info/paths"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputDir := t.TempDir()
+	options := BuildOptions{
+		OutputFile:  filepath.Join(outputDir, "first.tar.zst"),
+		BuildDir:    t.TempDir(),
+		FakeRootDir: t.TempDir(),
+		TempDir:     t.TempDir(),
+		Compression: CompressionZstd,
+	}
+
+	differences, err := CheckReproducible(pkg, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(differences) != 0 {
+		t.Errorf("builds differ: %v", differences)
+	}
+
+	second := options
+	second.OutputFile = filepath.Join(outputDir, "second.tar.zst")
+	second.Reproducible = true
+	if err := Build(pkg, second); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(options.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := os.ReadFile(second.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, again) {
+		t.Error("archives of two builds are not identical")
+	}
+
+	for _, parent := range []string{options.BuildDir, options.FakeRootDir, options.TempDir} {
+		if _, err := os.Stat(filepath.Join(parent, pkg.Name)); !os.IsNotExist(err) {
+			t.Errorf("work directory left in %s: %v", parent, err)
+		}
+	}
+}
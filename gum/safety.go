@@ -0,0 +1,210 @@
+package gum
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	maxSymlinkDepth = 255
+	parentDirName   = ".."
+)
+
+// ArchiveIssue describes archive entry that would be written outside of the target root.
+type ArchiveIssue struct {
+	Archive string
+	Name    string
+	Problem string
+}
+
+func (i ArchiveIssue) Error() string {
+	return fmt.Sprintf("%s: %s: %s", i.Archive, i.Name, i.Problem)
+}
+
+// entryValidator checks archive entries one by one, remembering symlinks created by earlier entries.
+type entryValidator struct {
+	archive  string
+	symlinks map[string]bool
+}
+
+func newEntryValidator(archive string) *entryValidator {
+	return &entryValidator{archive: archive, symlinks: make(map[string]bool)}
+}
+
+// validate returns issue when entry name or link target is absolute, refers to parent directory,
+// leads through a symlink extracted from the same archive or the entry type is not supported.
+func (v *entryValidator) validate(header *tar.Header) *ArchiveIssue {
+	if problem := v.checkPath(header.Name); problem != "" {
+		return v.issue(header.Name, problem)
+	}
+
+	name := path.Clean(header.Name)
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+	case tar.TypeLink:
+		if problem := v.checkPath(header.Linkname); problem != "" {
+			return v.issue(header.Name, "hard link target "+header.Linkname+": "+problem)
+		}
+	case tar.TypeSymlink:
+		v.symlinks[name] = true
+		if !path.IsAbs(header.Linkname) {
+			target := path.Join(path.Dir(name), header.Linkname)
+			if target == parentDirName || strings.HasPrefix(target, parentDirName+"/") {
+				return v.issue(header.Name, "symlink target "+header.Linkname+" escapes root")
+			}
+		}
+	default:
+		return v.issue(header.Name, fmt.Sprintf("unsupported entry type %q", header.Typeflag))
+	}
+
+	return nil
+}
+
+func (v *entryValidator) checkPath(name string) string {
+	if name == "" {
+		return "empty name"
+	}
+	if path.IsAbs(name) {
+		return "absolute path"
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == parentDirName {
+			return "reference to parent directory"
+		}
+	}
+
+	for dir := path.Dir(path.Clean(name)); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if v.symlinks[dir] {
+			return "leads through symlink " + dir + " from the archive"
+		}
+	}
+
+	return ""
+}
+
+func (v *entryValidator) issue(name, problem string) *ArchiveIssue {
+	return &ArchiveIssue{Archive: v.archive, Name: name, Problem: problem}
+}
+
+// VerifyArchive reports entries of package archive and of its files archive that are unsafe to extract,
+// without extracting anything.
+func VerifyArchive(archivePath string) ([]ArchiveIssue, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := newDecompressingReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	issues := make([]ArchiveIssue, 0)
+	packageIssues, err := collectArchiveIssues(filepath.Base(archivePath), tar.NewReader(reader), func(header *tar.Header, tarReader *tar.Reader) error {
+		if path.Clean(header.Name) != FilesArchiveFileName || header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		filesIssues, err := collectArchiveIssues(FilesArchiveFileName, tar.NewReader(tarReader), nil)
+		issues = append(issues, filesIssues...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(packageIssues, issues...), nil
+}
+
+// collectArchiveIssues validates every entry of tar stream, calling visit for each of them if given.
+func collectArchiveIssues(archiveName string, tarReader *tar.Reader, visit func(*tar.Header, *tar.Reader) error) ([]ArchiveIssue, error) {
+	validator := newEntryValidator(archiveName)
+	issues := make([]ArchiveIssue, 0)
+	for {
+		header, err := tarReader.Next()
+		switch {
+		case err == io.EOF:
+			return issues, nil
+		case err != nil:
+			return issues, err
+		}
+
+		if issue := validator.validate(header); issue != nil {
+			issues = append(issues, *issue)
+		}
+		if visit != nil {
+			if err := visit(header, tarReader); err != nil {
+				return issues, err
+			}
+		}
+	}
+}
+
+// resolveInRoot joins path onto root, resolving symlinks in its parent directories as if root
+// was the filesystem root, so neither parent references nor absolute symlinks can leave it.
+// The last component is not resolved, so it can be replaced rather than followed.
+func resolveInRoot(root, name string) (string, error) {
+	dir, base := path.Split(path.Clean("/" + name))
+	if base == "" {
+		return root, nil
+	}
+
+	resolvedDir, err := resolveDirInRoot(root, dir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(resolvedDir, base), nil
+}
+
+// resolveDirInRoot works like resolveInRoot, but resolves every component of path.
+func resolveDirInRoot(root, name string) (string, error) {
+	remaining := strings.Split(name, "/")
+	current := "/"
+	links := 0
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case parentDirName:
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, component)
+		hostPath := filepath.Join(root, next)
+		info, err := os.Lstat(hostPath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinkDepth {
+			return "", errors.New(name + ": too many levels of symbolic links")
+		}
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			current = "/"
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+
+	return filepath.Join(root, current), nil
+}
@@ -0,0 +1,208 @@
+package gum
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEntryValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+		// problem is expected in issue of the last entry, empty when every entry is valid
+		problem string
+	}{
+		{"regular file", []*tar.Header{
+			{Name: "usr/bin/tool", Typeflag: tar.TypeReg},
+		}, ""},
+		{"parent reference", []*tar.Header{
+			{Name: "../escape", Typeflag: tar.TypeReg},
+		}, "reference to parent directory"},
+		{"parent reference inside path", []*tar.Header{
+			{Name: "usr/../../escape", Typeflag: tar.TypeReg},
+		}, "reference to parent directory"},
+		{"absolute name", []*tar.Header{
+			{Name: "/etc/passwd", Typeflag: tar.TypeReg},
+		}, "absolute path"},
+		{"absolute directory", []*tar.Header{
+			{Name: "/etc/", Typeflag: tar.TypeDir},
+		}, "absolute path"},
+		{"empty name", []*tar.Header{
+			{Name: "", Typeflag: tar.TypeReg},
+		}, "empty name"},
+		{"file through symlink from archive", []*tar.Header{
+			{Name: "lnk", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+			{Name: "lnk/passwd", Typeflag: tar.TypeReg},
+		}, "leads through symlink lnk"},
+		{"file through nested symlink from archive", []*tar.Header{
+			{Name: "usr/lnk", Typeflag: tar.TypeSymlink, Linkname: "../etc"},
+			{Name: "usr/lnk/sub/passwd", Typeflag: tar.TypeReg},
+		}, "leads through symlink usr/lnk"},
+		{"symlink outside root", []*tar.Header{
+			{Name: "usr/lib/lnk", Typeflag: tar.TypeSymlink, Linkname: "../../../etc"},
+		}, "escapes root"},
+		{"relative symlink inside root", []*tar.Header{
+			{Name: "usr/lib/lnk", Typeflag: tar.TypeSymlink, Linkname: "../share/data"},
+		}, ""},
+		{"absolute symlink resolved in root", []*tar.Header{
+			{Name: "etc/mtab", Typeflag: tar.TypeSymlink, Linkname: "/proc/self/mounts"},
+		}, ""},
+		{"hard link outside root", []*tar.Header{
+			{Name: "shadow", Typeflag: tar.TypeLink, Linkname: "../../etc/shadow"},
+		}, "hard link target ../../etc/shadow: reference to parent directory"},
+		{"hard link to absolute path", []*tar.Header{
+			{Name: "shadow", Typeflag: tar.TypeLink, Linkname: "/etc/shadow"},
+		}, "hard link target /etc/shadow: absolute path"},
+		{"hard link through symlink from archive", []*tar.Header{
+			{Name: "lnk", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+			{Name: "shadow", Typeflag: tar.TypeLink, Linkname: "lnk/shadow"},
+		}, "leads through symlink lnk"},
+		{"hard link inside root", []*tar.Header{
+			{Name: "usr/bin/a", Typeflag: tar.TypeReg},
+			{Name: "usr/bin/b", Typeflag: tar.TypeLink, Linkname: "usr/bin/a"},
+		}, ""},
+		{"unsupported type", []*tar.Header{
+			{Name: "usr/file", Typeflag: tar.TypeGNUSparse},
+		}, "unsupported entry type"},
+	}
+
+	for _, test := range tests {
+		validator := newEntryValidator("test.tar")
+		var issue *ArchiveIssue
+		for i, header := range test.entries {
+			issue = validator.validate(header)
+			if i < len(test.entries)-1 && issue != nil {
+				t.Fatalf("%s: unexpected issue of %s: %v", test.name, header.Name, issue)
+			}
+		}
+		switch {
+		case test.problem == "" && issue != nil:
+			t.Errorf("%s: unexpected issue %v", test.name, issue)
+		case test.problem != "" && issue == nil:
+			t.Errorf("%s: expected issue %q", test.name, test.problem)
+		case test.problem != "" && !strings.Contains(issue.Problem, test.problem):
+			t.Errorf("%s: expected issue %q, got %q", test.name, test.problem, issue.Problem)
+		}
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	files := &bytes.Buffer{}
+	writeTestTar(t, files, []*tar.Header{
+		{Name: "usr/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "lnk", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		{Name: "lnk/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "out", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+		{Name: "shadow", Typeflag: tar.TypeLink, Linkname: "../../etc/shadow"},
+		{Name: "/etc/issue", Typeflag: tar.TypeReg, Mode: 0644},
+	}, nil)
+
+	archivePath := filepath.Join(t.TempDir(), "bad.tar")
+	archive := &bytes.Buffer{}
+	writeTestTar(t, archive, []*tar.Header{
+		{Name: FilesArchiveFileName, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(files.Len())},
+	}, [][]byte{files.Bytes()})
+	if err := os.WriteFile(archivePath, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := VerifyArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		if issue.Archive != FilesArchiveFileName {
+			t.Errorf("issue reported in %s: %v", issue.Archive, issue)
+		}
+		names = append(names, issue.Name)
+	}
+	expected := []string{"../escape", "lnk/passwd", "out", "shadow", "/etc/issue"}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("issues reported for %v, expected %v", names, expected)
+	}
+}
+
+// writeTestTar writes entries to tar stream, regular entries get content of the same index, if any.
+func writeTestTar(t *testing.T, buffer *bytes.Buffer, headers []*tar.Header, contents [][]byte) {
+	writer := tar.NewWriter(buffer)
+	for i, header := range headers {
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if i < len(contents) {
+			if _, err := writer.Write(contents[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"etc", "usr/lib"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"lnk":         "/etc",
+		"rel":         "usr/lib",
+		"up":          "../../..",
+		"usr/lib/up":  "../../../../etc",
+		"loop":        "loop",
+		"usr/lib/abs": "/usr",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		resolved string
+		dir      bool
+	}{
+		{"usr/lib/libc.so", "usr/lib/libc.so", false},
+		{"/usr/lib/libc.so", "usr/lib/libc.so", false},
+		{"../escape", "escape", false},
+		{"../../etc/shadow", "etc/shadow", false},
+		{"usr/../../../etc/shadow", "etc/shadow", false},
+		{"lnk/passwd", "etc/passwd", false},
+		{"rel/libc.so", "usr/lib/libc.so", false},
+		{"up/etc/shadow", "etc/shadow", false},
+		{"usr/lib/up/shadow", "etc/shadow", false},
+		{"usr/lib/abs/bin", "usr/bin", false},
+		// last component is not followed, so links themselves can be replaced
+		{"lnk", "lnk", false},
+		{"lnk", "etc", true},
+		{"up", "", true},
+		{"/", "", false},
+	}
+	for _, test := range tests {
+		resolve := resolveInRoot
+		if test.dir {
+			resolve = resolveDirInRoot
+		}
+		resolved, err := resolve(root, test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if expected := filepath.Join(root, test.resolved); resolved != expected {
+			t.Errorf("%s (dir %v): resolved to %s, expected %s", test.name, test.dir, resolved, expected)
+		}
+	}
+
+	if _, err := resolveInRoot(root, "loop/file"); err == nil || !strings.Contains(err.Error(), "too many levels") {
+		t.Errorf("symlink loop: expected too many levels error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	selfExecutable = "/proc/self/exe"
+	tempDirEnvVar  = "TMPDIR"
+)
+
+// runScriptInSandbox executes bash script in new user, mount and network namespace. The script runs as root
+// mapped to the invoking user, has no network and can write only to writable directories. No other user or
+// group is mapped, subordinate id ranges are not used, so chown to any owner other than root fails with EINVAL
+// and files stay owned by the builder. Such calls are only recorded by ownershipRecordingPrelude.
+func runScriptInSandbox(dir, logic string, env []string, output scriptOutput, writableDirs []string) error {
+	cmd := exec.Command(selfExecutable)
+	cmd.Dir = dir
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, SandboxEnvVarName+"="+strings.Join(writableDirs, string(filepath.ListSeparator)))
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+	}
+
+	return runScript(cmd, logic, output)
+}
+
+// IsSandboxChild checks if process was started by runScriptInSandbox to set up the sandbox.
+func IsSandboxChild() bool {
+	_, ok := os.LookupEnv(SandboxEnvVarName)
+	return ok
+}
+
+// RunSandboxChild makes whole filesystem read only except for writable directories and replaces
+// the process with bash reading script from standard input. It never returns.
+func RunSandboxChild() {
+	writableDirs := filepath.SplitList(os.Getenv(SandboxEnvVarName))
+	if err := os.Unsetenv(SandboxEnvVarName); err != nil {
+		exitSandbox(err)
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		exitSandbox(err)
+	}
+	if err := setUpSandboxMounts(writableDirs); err != nil {
+		exitSandbox(err)
+	}
+	// working directory still refers to the mount underneath the writable bind mount
+	if err := os.Chdir(workDir); err != nil {
+		exitSandbox(err)
+	}
+	if len(writableDirs) > 0 {
+		if err := os.Setenv(tempDirEnvVar, writableDirs[len(writableDirs)-1]); err != nil {
+			exitSandbox(err)
+		}
+	}
+
+	shell, err := exec.LookPath(scriptCommand)
+	if err != nil {
+		exitSandbox(err)
+	}
+	exitSandbox(syscall.Exec(shell, []string{scriptCommand}, os.Environ()))
+}
+
+func setUpSandboxMounts(writableDirs []string) error {
+	if err := unix.Mount("", RootDir, "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+
+	readOnly := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	if err := unix.MountSetattr(unix.AT_FDCWD, RootDir, unix.AT_RECURSIVE, readOnly); err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			return errors.New("build sandbox requires Linux 5.12 or newer")
+		}
+		return fmt.Errorf("make filesystem read only: %w", err)
+	}
+
+	readWrite := &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY}
+	for _, dir := range writableDirs {
+		if err := unix.Mount(dir, dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind %s: %w", dir, err)
+		}
+		if err := unix.MountSetattr(unix.AT_FDCWD, dir, unix.AT_RECURSIVE, readWrite); err != nil {
+			return fmt.Errorf("make %s writable: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+func exitSandbox(err error) {
+	fmt.Fprintln(os.Stderr, "sandbox:", err)
+	os.Exit(1)
+}
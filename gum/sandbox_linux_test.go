@@ -0,0 +1,64 @@
+package gum
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// sandbox is set up by re-executing the running binary, which is the test binary here
+	if IsSandboxChild() {
+		RunSandboxChild()
+	}
+	os.Exit(m.Run())
+}
+
+// TestSandboxMapsOnlyBuilder documents that only the builder is mapped into the sandbox, as root, so chown to
+// other ids fails there and has to be recorded instead.
+func TestSandboxMapsOnlyBuilder(t *testing.T) {
+	config := DefaultConfig()
+	config.LogDir = t.TempDir()
+	useConfig(t, config)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := scriptOutput{pkg: "sandbox", stage: StageBuild}
+	if err := runScriptInSandbox(dir, "true", nil, output, []string{dir}); err != nil {
+		t.Skipf("build sandbox not available: %v", err)
+	}
+
+	if err := runScriptInSandbox(dir, "chown 0:0 file", nil, output, []string{dir}); err != nil {
+		t.Errorf("chown to root: %v", err)
+	}
+
+	err := runScriptInSandbox(dir, "chown 1234:99 file", nil, output, []string{dir})
+	scriptErr := &ScriptError{}
+	if !errors.As(err, &scriptErr) || !strings.Contains(strings.Join(scriptErr.Tail, "\n"), "Invalid argument") {
+		t.Fatalf("chown to unmapped id: expected Invalid argument, got %v", err)
+	}
+
+	recordFile := filepath.Join(dir, ownershipFileName)
+	env := append(os.Environ(), OwnershipFileEnvVarName+"="+recordFile)
+	if err := runScriptInSandbox(dir, ownershipRecordingPrelude+"chown 1234:99 file", env, output, []string{dir}); err != nil {
+		t.Fatalf("recorded chown to unmapped id: %v", err)
+	}
+	table, err := newOwnershipTable(recordFile, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := table["file"]; entry == nil || entry.owner != "1234" || entry.group != "99" {
+		t.Errorf("chown not recorded: %+v", entry)
+	}
+	info, err := os.Stat(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Getuid() {
+		t.Errorf("file owned by %d, expected builder %d", uid, os.Getuid())
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package gum
+
+import "errors"
+
+func runScriptInSandbox(dir, logic string, env []string, output scriptOutput, writableDirs []string) error {
+	return errors.New("build sandbox is supported only on Linux")
+}
+
+// IsSandboxChild checks if process was started by runScriptInSandbox to set up the sandbox.
+func IsSandboxChild() bool {
+	return false
+}
+
+// RunSandboxChild is never called on systems without build sandbox.
+func RunSandboxChild() {}
@@ -0,0 +1,258 @@
+package gum
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	StageBuild         = "build"
+	StageBeforeInstall = "before_install"
+	StageAfterInstall  = "after_install"
+	StageUninstall     = "uninstall"
+	StageBeforeUpgrade = "before_upgrade"
+	StageAfterUpgrade  = "after_upgrade"
+
+	logFileExtension = ".log"
+	// stateHomeEnvVarName names base directory of user state files, logs fall back to it
+	stateHomeEnvVarName = "XDG_STATE_HOME"
+	fallbackLogDirName  = "logs"
+	// tempLogDirName is log directory inside temp directory, starting with dot so it is never work directory
+	// of a package
+	tempLogDirName = ".logs"
+	// logTailLines is number of last log lines reported when script fails
+	logTailLines = 20
+	logTailBytes = 64 * 1024
+)
+
+// LogStages lists stages package scripts are run in, in order shown by show log.
+var LogStages = []string{StageBuild, StageBeforeInstall, StageAfterInstall, StageUninstall, StageBeforeUpgrade, StageAfterUpgrade}
+
+// scriptOutput says where output of package script goes. It is always written to log file of package and stage,
+// verbose output is copied to terminal as well.
+type scriptOutput struct {
+	pkg     string
+	stage   string
+	verbose bool
+}
+
+// PackageLog is output of package script written during its last run.
+type PackageLog struct {
+	Package  string    `json:"package" yaml:"package"`
+	Stage    string    `json:"stage" yaml:"stage"`
+	Path     string    `json:"path" yaml:"path"`
+	Modified time.Time `json:"modified" yaml:"modified"`
+	Content  string    `json:"content" yaml:"content"`
+}
+
+// ScriptError reports failed package script together with the end of its log.
+type ScriptError struct {
+	Package string
+	Stage   string
+	LogPath string
+	Tail    []string
+	Err     error
+}
+
+func (e *ScriptError) Error() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s script of %s failed: %v", e.Stage, e.Package, e.Err))
+	if e.LogPath != "" {
+		sb.WriteString(fmt.Sprintf("\nlast lines of %s:", e.LogPath))
+		for _, line := range e.Tail {
+			sb.WriteString("\n")
+			sb.WriteString(line)
+		}
+	}
+
+	return sb.String()
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// logDirs returns directories logs are written to, in order of preference: configured log directory, then
+// gumshield directory in XDG_STATE_HOME or ~/.local/state, then temp directory, used when the configured one
+// is not writable, e.g. for builds run by normal user.
+func logDirs() []string {
+	config := currentConfig()
+	dirs := []string{config.LogDir}
+	stateDir := os.Getenv(stateHomeEnvVarName)
+	if stateDir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			stateDir = filepath.Join(home, ".local", "state")
+		}
+	}
+	if stateDir != "" {
+		dirs = append(dirs, filepath.Join(stateDir, "gumshield", fallbackLogDirName))
+	}
+
+	return append(dirs, filepath.Join(config.TempDir, tempLogDirName))
+}
+
+// logPath returns path of log file of package script run in stage inside logDir. Paths leaving logDir are refused.
+func logPath(logDir, pkg, stage string) (string, error) {
+	if err := validatePackageName(pkg); err != nil {
+		return "", err
+	}
+	path := filepath.Join(logDir, pkg, stage+logFileExtension)
+	relative, err := filepath.Rel(logDir, path)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("log of %s script of %s is outside of %s", stage, pkg, logDir)
+	}
+
+	return path, nil
+}
+
+// open creates log file of script, replacing log of its previous run. Log is written to the first of logDirs
+// it can be created in, saying where it went when it is not the configured log directory. Scripts are run
+// without log, with a warning, when log file cannot be created anywhere.
+func (o scriptOutput) open() *os.File {
+	var lastErr error
+	dirs := logDirs()
+	for i, logDir := range dirs {
+		path, err := logPath(logDir, o.pkg, o.stage)
+		if err != nil {
+			lastErr = err
+			break
+		}
+		file, err := createLog(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintf(os.Stderr, "warning: cannot write logs to %s, logging %s script of %s to %s\n", dirs[0], o.stage, o.pkg, path)
+		}
+		return file
+	}
+
+	fmt.Fprintf(os.Stderr, "warning: not logging %s script of %s: %v\n", o.stage, o.pkg, lastErr)
+	return nil
+}
+
+// createLog creates log file at path together with its directory.
+func createLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return os.Create(path)
+}
+
+// failure wraps error of script run in ScriptError with the end of log written to logFile, if any.
+func (o scriptOutput) failure(err error, logFile *os.File) error {
+	scriptErr := &ScriptError{Package: o.pkg, Stage: o.stage, Err: err}
+	if logFile == nil {
+		return scriptErr
+	}
+	if tail, tailErr := readTail(logFile.Name(), logTailLines); tailErr == nil {
+		scriptErr.LogPath, scriptErr.Tail = logFile.Name(), tail
+	}
+
+	return scriptErr
+}
+
+// readTail returns up to count last lines of file, looking only at its last logTailBytes.
+func readTail(path string, count int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - logTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimRight(string(content), "\n")
+	if text == "" {
+		return []string{}, nil
+	}
+	lines := strings.Split(text, "\n")
+	if offset > 0 {
+		// first line was cut
+		lines = lines[1:]
+	}
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+
+	return lines, nil
+}
+
+// PackageLogs returns logs of every script of package run so far, or of the given stage only. Logs are looked
+// for in every one of logDirs, the most recent log of each stage is returned.
+func PackageLogs(name, stage string) ([]PackageLog, error) {
+	stages := LogStages
+	if stage != "" {
+		stages = []string{stage}
+	}
+
+	logs := make([]PackageLog, 0)
+	for _, stage := range stages {
+		var latest *PackageLog
+		for _, logDir := range logDirs() {
+			path, err := logPath(logDir, name, stage)
+			if err != nil {
+				return nil, err
+			}
+			log, err := readLog(path)
+			if err != nil {
+				return nil, err
+			}
+			if log != nil && (latest == nil || log.Modified.After(latest.Modified)) {
+				latest = log
+			}
+		}
+		if latest != nil {
+			latest.Package, latest.Stage = name, stage
+			logs = append(logs, *latest)
+		}
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("%s: no logs", name)
+	}
+
+	return logs, nil
+}
+
+// readLog reads log file at path, missing file gives nil log.
+func readLog(path string) (*PackageLog, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PackageLog{Path: path, Modified: info.ModTime(), Content: string(content)}, nil
+}
@@ -1,70 +1,184 @@
 package gum
 
 import (
-	"fmt"
+	"time"
 )
 
-func ShowInstalled() error {
-	packages, err := readPackagesFromIndex()
+// PackageSummary is entry of installed packages list.
+type PackageSummary struct {
+	Name        string    `json:"name" yaml:"name"`
+	Version     string    `json:"version" yaml:"version"`
+	Reason      string    `json:"reason" yaml:"reason"`
+	InstallTime time.Time `json:"install_time" yaml:"install_time"`
+}
+
+// PackageDetails describes package, install time and reason are set only for installed packages.
+type PackageDetails struct {
+	Name            string     `json:"name" yaml:"name"`
+	Version         string     `json:"version" yaml:"version"`
+	Description     string     `json:"description" yaml:"description"`
+	Depends         []string   `json:"depends" yaml:"depends"`
+	OptionalDepends []string   `json:"optional_depends" yaml:"optional_depends"`
+	InstallTime     *time.Time `json:"install_time,omitempty" yaml:"install_time,omitempty"`
+	Reason          string     `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Files           []string   `json:"files" yaml:"files"`
+}
+
+// PackageTriggers holds scripts of package, empty when package does not define them.
+type PackageTriggers struct {
+	Build         string `json:"build" yaml:"build"`
+	BeforeInstall string `json:"before_install" yaml:"before_install"`
+	AfterInstall  string `json:"after_install" yaml:"after_install"`
+	Uninstall     string `json:"uninstall" yaml:"uninstall"`
+	BeforeUpgrade string `json:"before_upgrade" yaml:"before_upgrade"`
+	AfterUpgrade  string `json:"after_upgrade" yaml:"after_upgrade"`
+}
+
+// ListInstalled returns packages installed in system at root, sorted by name.
+func ListInstalled(root string) ([]PackageSummary, error) {
+	db, err := openDatabase(root)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, v := range packages {
-		fmt.Println(v.Name)
+	packages := make([]PackageSummary, 0, len(db.Packages))
+	for _, pkg := range db.list() {
+		packages = append(packages, PackageSummary{Name: pkg.Name, Version: pkg.Version, Reason: pkg.Reason, InstallTime: pkg.InstallTime})
 	}
 
-	return nil
+	return packages, nil
 }
 
-func ShowConfig() error {
-	return nil
+// EffectiveConfig returns value of every configuration key with its source.
+func EffectiveConfig() ([]ConfigValue, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Values(), nil
 }
 
-func ShowFiles(packageName string) error {
-	pkg, err := getPackageFromIndex(packageName)
+// InstalledPackageFiles returns records of files of installed package.
+func InstalledPackageFiles(packageName, root string) ([]FileRecord, error) {
+	pkg, err := getInstalledPackage(root, packageName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, file := range pkg.Files {
-		fmt.Println(file)
+	return nonNilFiles(pkg.Files), nil
+}
+
+// InstalledPackageDetails describes installed package.
+func InstalledPackageDetails(packageName, root string) (*PackageDetails, error) {
+	pkg, err := getInstalledPackage(root, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	installTime := pkg.InstallTime
+	return &PackageDetails{
+		Name:            pkg.Name,
+		Version:         pkg.Version,
+		Description:     pkg.Description,
+		Depends:         nonNilStrings(pkg.Depends),
+		OptionalDepends: nonNilStrings(pkg.OptionalDepends),
+		InstallTime:     &installTime,
+		Reason:          pkg.Reason,
+		Files:           pkg.paths(),
+	}, nil
+}
+
+// InstalledPackageTriggers returns scripts of installed package.
+func InstalledPackageTriggers(packageName, root string) (*PackageTriggers, error) {
+	installed, err := getInstalledPackage(root, packageName)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := installed.definition()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return newPackageTriggers(pkg), nil
 }
 
-func ShowPackage(packageName string) error {
-	pkg, err := getPackageFromIndex(packageName)
+// ArchivePackageDetails describes package in archive without installing it.
+func ArchivePackageDetails(archivePath string) (*PackageDetails, error) {
+	pkg, _, err := inspectPackageArchive(archivePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Println("name:", pkg.Name)
-	fmt.Println("version:", pkg.Version)
-	fmt.Println("description:", pkg.Description)
-	fmt.Println("files:")
+	files := make([]string, 0, len(pkg.Files))
 	for _, file := range pkg.Files {
-		fmt.Println(file)
+		files = append(files, normalizePackagePath(file))
 	}
 
-	return nil
+	return &PackageDetails{
+		Name:            pkg.Name,
+		Version:         pkg.Version,
+		Description:     pkg.Description,
+		Depends:         nonNilStrings(pkg.Depends),
+		OptionalDepends: nonNilStrings(pkg.OptionalDepends),
+		Files:           files,
+	}, nil
 }
 
-func ShowTriggers(packageName string) error {
-	pkg, err := getPackageFromIndex(packageName)
+// ArchivePackageFiles returns records of files in package archive, with checksums recorded in its definition.
+func ArchivePackageFiles(archivePath string) ([]FileRecord, error) {
+	pkg, headers, err := inspectPackageArchive(archivePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Println("build:")
-	fmt.Println(pkg.BuildLogic)
-	fmt.Println("before install:")
-	fmt.Println(pkg.BeforeInstallLogic)
-	fmt.Println("after install:")
-	fmt.Println(pkg.AfterInstallLogic)
-	fmt.Println("uninstall:")
-	fmt.Println(pkg.UninstallLogic)
+	return nonNilFiles(newInstalledPackage(pkg, "", headers, "", nil).Files), nil
+}
+
+// ArchivePackageTriggers returns scripts of package in archive.
+func ArchivePackageTriggers(archivePath string) (*PackageTriggers, error) {
+	pkg, _, err := inspectPackageArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPackageTriggers(pkg), nil
+}
+
+func newPackageTriggers(pkg *PackageDefinition) *PackageTriggers {
+	return &PackageTriggers{
+		Build:         pkg.BuildLogic,
+		BeforeInstall: pkg.BeforeInstallLogic,
+		AfterInstall:  pkg.AfterInstallLogic,
+		Uninstall:     pkg.UninstallLogic,
+		BeforeUpgrade: pkg.BeforeUpgradeLogic,
+		AfterUpgrade:  pkg.AfterUpgradeLogic,
+	}
+}
+
+// nonNilStrings returns empty list instead of nil, so lists are never rendered as null.
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
+
+// nonNilFiles returns empty list instead of nil, like nonNilStrings.
+func nonNilFiles(files []FileRecord) []FileRecord {
+	if files == nil {
+		return []FileRecord{}
+	}
+
+	return files
+}
+
+func getInstalledPackage(root, packageName string) (*InstalledPackage, error) {
+	db, err := openDatabase(root)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	return db.get(packageName)
 }
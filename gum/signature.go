@@ -0,0 +1,242 @@
+package gum
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	privateKeyPemType = "PRIVATE KEY"
+	publicKeyPemType  = "PUBLIC KEY"
+
+	PrivateKeyFileExtension = ".key"
+	PublicKeyFileExtension  = ".pub"
+
+	signatureKeyField       = "key"
+	signatureSignatureField = "signature"
+	keyIDLength             = 16
+)
+
+// signedFiles are files of package archive covered by signature, in order of signed message.
+var signedFiles = []string{DefinitionFileName, FilesArchiveFileName}
+
+// ErrUnsigned is returned when package archive has no signature or is signed by a key missing from keyring.
+var ErrUnsigned = errors.New("package is not signed by a trusted key")
+
+// GenerateKey creates new Ed25519 key pair and writes private key to path with .key extension
+// and public key to path with .pub extension. Existing files are never overwritten.
+// Returns identifier of the key.
+func GenerateKey(path string) (string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeNewFile(path+PrivateKeyFileExtension, pem.EncodeToMemory(&pem.Block{Type: privateKeyPemType, Bytes: privateDer}), 0600); err != nil {
+		return "", err
+	}
+	if err := writeNewFile(path+PublicKeyFileExtension, pem.EncodeToMemory(&pem.Block{Type: publicKeyPemType, Bytes: publicDer}), 0644); err != nil {
+		return "", err
+	}
+
+	return keyID(publicKey), nil
+}
+
+func writeNewFile(path string, content []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// keyID returns short identifier of public key.
+func keyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])[:keyIDLength]
+}
+
+// readPrivateKey reads PEM encoded Ed25519 private key.
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPemFile(path, privateKeyPemType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+
+	return privateKey, nil
+}
+
+// readPublicKey reads PEM encoded Ed25519 public key.
+func readPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPemFile(path, publicKeyPemType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+
+	return publicKey, nil
+}
+
+func readPemFile(path, blockType string) (*pem.Block, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: expected PEM encoded %s", path, strings.ToLower(blockType))
+	}
+
+	return block, nil
+}
+
+// readKeyring reads every public key with .pub extension from directory, missing directory is an empty keyring.
+func readKeyring(dir string) (map[string]ed25519.PublicKey, error) {
+	keys := make(map[string]ed25519.PublicKey)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != PublicKeyFileExtension {
+			continue
+		}
+		key, err := readPublicKey(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys[keyID(key)] = key
+	}
+
+	return keys, nil
+}
+
+// signedMessage returns message signed for package unpacked in dir, listing SHA-256 digests of signed files.
+func signedMessage(dir string) ([]byte, error) {
+	sb := strings.Builder{}
+	for _, name := range signedFiles {
+		digest, err := fileDigest(filepath.Join(dir, name), sha256Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(fmt.Sprintf("%s %s:%s\n", name, sha256Algorithm, digest))
+	}
+
+	return []byte(sb.String()), nil
+}
+
+// signPackage writes signature of manifest and files archive found in dir into signature file.
+func signPackage(dir string, key ed25519.PrivateKey) error {
+	message, err := signedMessage(dir)
+	if err != nil {
+		return err
+	}
+	signature := ed25519.Sign(key, message)
+
+	content := string(message) +
+		fmt.Sprintf("%s %s\n", signatureKeyField, keyID(key.Public().(ed25519.PublicKey))) +
+		fmt.Sprintf("%s %s\n", signatureSignatureField, base64.StdEncoding.EncodeToString(signature))
+
+	return os.WriteFile(filepath.Join(dir, SignatureFileName), []byte(content), 0644)
+}
+
+// verifyPackageSignature checks signature of package unpacked in dir with keys from keyring directory.
+// ErrUnsigned is returned for packages without signature or signed by unknown key, any other error
+// means the signature does not match package content.
+func verifyPackageSignature(dir, keyringDir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, SignatureFileName))
+	if os.IsNotExist(err) {
+		return ErrUnsigned
+	}
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		if name, value, ok := strings.Cut(line, " "); ok {
+			fields[name] = value
+		}
+	}
+	signature, err := base64.StdEncoding.DecodeString(fields[signatureSignatureField])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return errors.New("malformed package signature")
+	}
+
+	keys, err := readKeyring(keyringDir)
+	if err != nil {
+		return err
+	}
+	key, ok := keys[fields[signatureKeyField]]
+	if !ok {
+		return fmt.Errorf("%w: signed by unknown key %q", ErrUnsigned, fields[signatureKeyField])
+	}
+
+	message, err := signedMessage(dir)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, message, signature) {
+		return fmt.Errorf("bad package signature by key %s", fields[signatureKeyField])
+	}
+
+	return nil
+}
+
+// checkPackageSignature verifies signature of package unpacked in dir, accepting unsigned packages
+// only when allowed. Bad signatures are never accepted.
+func checkPackageSignature(dir string, allowUnsigned bool) error {
+	err := verifyPackageSignature(dir, currentConfig().KeyringDir)
+	if errors.Is(err, ErrUnsigned) && allowUnsigned {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		return nil
+	}
+	if errors.Is(err, ErrUnsigned) {
+		return fmt.Errorf("%w, use --allow_unsigned to install it anyway", err)
+	}
+
+	return err
+}
@@ -0,0 +1,149 @@
+package gum
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"gopkg.in/yaml.v3"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	sha256Algorithm = "sha256"
+	sha512Algorithm = "sha512"
+	b2Algorithm     = "b2"
+)
+
+// FileName returns name under which source is stored in build directory.
+func (s Source) FileName() string {
+	return path.Base(s.Url)
+}
+
+// HasChecksum checks if source declares any digest.
+func (s Source) HasChecksum() bool {
+	return s.Sha256 != "" || s.Sha512 != "" || s.B2 != ""
+}
+
+func (s *Source) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = Source{Url: node.Value}
+		return nil
+	}
+
+	type plainSource Source
+	source := plainSource{}
+	if err := node.Decode(&source); err != nil {
+		return err
+	}
+	if source.Url == "" {
+		return fmt.Errorf("line %d: source without url", node.Line)
+	}
+	*s = Source(source)
+
+	return nil
+}
+
+func (s Source) MarshalYAML() (interface{}, error) {
+	if !s.HasChecksum() {
+		return s.Url, nil
+	}
+
+	type plainSource Source
+	return plainSource(s), nil
+}
+
+// verifyChecksums compares every digest declared by source with digest of file at path.
+func (s Source) verifyChecksums(filePath string) error {
+	for _, algorithm := range []string{sha256Algorithm, sha512Algorithm, b2Algorithm} {
+		expected := s.checksum(algorithm)
+		if expected == "" {
+			continue
+		}
+
+		actual, err := fileDigest(filePath, algorithm)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(expected, actual) {
+			return fmt.Errorf("checksum mismatch for %s: expected %s:%s, got %s:%s", s.FileName(), algorithm, expected, algorithm, actual)
+		}
+	}
+
+	return nil
+}
+
+func (s Source) checksum(algorithm string) string {
+	switch algorithm {
+	case sha256Algorithm:
+		return s.Sha256
+	case sha512Algorithm:
+		return s.Sha512
+	case b2Algorithm:
+		return s.B2
+	}
+
+	return ""
+}
+
+// updateChecksums recomputes every digest declared by source, sha256 is used when source declares none.
+func (s *Source) updateChecksums(filePath string) error {
+	checksums := map[string]*string{sha256Algorithm: &s.Sha256}
+	if s.HasChecksum() {
+		checksums = map[string]*string{}
+		for algorithm, checksum := range map[string]*string{sha256Algorithm: &s.Sha256, sha512Algorithm: &s.Sha512, b2Algorithm: &s.B2} {
+			if *checksum != "" {
+				checksums[algorithm] = checksum
+			}
+		}
+	}
+
+	for algorithm, checksum := range checksums {
+		digest, err := fileDigest(filePath, algorithm)
+		if err != nil {
+			return err
+		}
+		*checksum = digest
+	}
+
+	return nil
+}
+
+// fileDigest returns hex encoded digest of file content.
+func fileDigest(filePath, algorithm string) (string, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case sha256Algorithm:
+		return sha256.New(), nil
+	case sha512Algorithm:
+		return sha512.New(), nil
+	case b2Algorithm:
+		return blake2b.New512(nil)
+	}
+
+	return nil, errors.New("unknown checksum algorithm " + algorithm)
+}
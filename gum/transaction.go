@@ -0,0 +1,180 @@
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	stagedFileSuffix = ".gumshield-new"
+	backupFileSuffix = ".gumshield-old"
+)
+
+// transaction records filesystem changes made while installing a package, so they can be undone.
+// New files are written next to their destination and renamed over it, previous files are kept as backups
+// until the transaction is committed.
+type transaction struct {
+	changes []fileChange
+	touched map[string]bool
+}
+
+type fileChange struct {
+	path      string
+	backup    string
+	directory bool
+}
+
+func newTransaction() *transaction {
+	return &transaction{changes: make([]fileChange, 0), touched: make(map[string]bool)}
+}
+
+// create writes new file through write function at staging path and moves it to path.
+func (t *transaction) create(path string, write func(stagedPath string) error) error {
+	stagedPath := path + stagedFileSuffix
+	if err := os.RemoveAll(stagedPath); err != nil {
+		return err
+	}
+	if err := write(stagedPath); err != nil {
+		_ = os.Remove(stagedPath)
+		return err
+	}
+
+	if err := t.backup(path); err != nil {
+		_ = os.Remove(stagedPath)
+		return err
+	}
+	if err := os.Rename(stagedPath, path); err != nil {
+		_ = os.Remove(stagedPath)
+		return err
+	}
+
+	return nil
+}
+
+// remove moves file at path to backup.
+func (t *transaction) remove(path string) error {
+	return t.backup(path)
+}
+
+// mkdirAll creates directory with all missing parents, remembering which of them did not exist.
+func (t *transaction) mkdirAll(path string, perm os.FileMode) error {
+	missing := make([]string, 0)
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], perm); err != nil {
+			return err
+		}
+		t.changes = append(t.changes, fileChange{path: missing[i], directory: true})
+	}
+
+	return nil
+}
+
+// backup moves existing file at path aside and records the change. Files already changed by the transaction
+// are removed, as the first backup holds their original content.
+func (t *transaction) backup(path string) error {
+	if t.touched[path] {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	change := fileChange{path: path}
+
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return fmt.Errorf("cannot replace directory %s", path)
+	case err == nil:
+		change.backup = path + backupFileSuffix
+		if err := os.RemoveAll(change.backup); err != nil {
+			return err
+		}
+		if err := os.Rename(path, change.backup); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	t.changes = append(t.changes, change)
+	t.touched[path] = true
+	return nil
+}
+
+// commit removes backups of replaced files.
+func (t *transaction) commit() error {
+	for _, change := range t.changes {
+		if change.backup == "" {
+			continue
+		}
+		if err := os.Remove(change.backup); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	t.changes = nil
+	t.touched = make(map[string]bool)
+
+	return nil
+}
+
+// rollback undoes recorded changes in reverse order, restoring backups of replaced files.
+func (t *transaction) rollback() error {
+	failures := make([]string, 0)
+	for i := len(t.changes) - 1; i >= 0; i-- {
+		change := t.changes[i]
+		if change.directory {
+			if err := os.Remove(change.path); err != nil && !os.IsNotExist(err) {
+				failures = append(failures, err.Error())
+			}
+			continue
+		}
+
+		if err := os.Remove(change.path); err != nil && !os.IsNotExist(err) {
+			failures = append(failures, err.Error())
+			continue
+		}
+		if change.backup == "" {
+			continue
+		}
+		if err := os.Rename(change.backup, change.path); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	t.changes = nil
+	t.touched = make(map[string]bool)
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// abort rolls transaction back and returns err extended with rollback failure, if any.
+func (t *transaction) abort(err error) error {
+	if rollbackErr := t.rollback(); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+	}
+
+	return err
+}
@@ -1,5 +1,9 @@
 package gum
 
+import (
+	"time"
+)
+
 type PackageDefinition struct {
 	Name               string
 	Version            string
@@ -8,18 +12,75 @@ type PackageDefinition struct {
 	BeforeInstallLogic string
 	AfterInstallLogic  string
 	UninstallLogic     string
-	Sources            []string
+	BeforeUpgradeLogic string
+	AfterUpgradeLogic  string
+	Sources            []Source
+	Depends            []string
+	BuildDepends       []string
+	OptionalDepends    []string
+	Permissions        []FilePermission
 	Files              []string
+	FileEntries        []PackageFile
+}
+
+// PackageFile is a FILES section entry of package archive definition, describing file as it was packaged.
+// Size and checksum are recorded only for regular files.
+type PackageFile struct {
+	Path   string
+	Mode   int64
+	Size   int64
+	Sha256 string
 }
 
 type PackageMetadata struct {
-	Name    string
-	Version string
-	Sources []string
-	/*
-		Sources []struct {
-			Url      string
-			Checksum string
-		}
-	*/
+	Name            string
+	Version         string
+	Sources         []Source         `yaml:",omitempty"`
+	Depends         []string         `yaml:",omitempty"`
+	BuildDepends    []string         `yaml:"build_depends,omitempty"`
+	OptionalDepends []string         `yaml:"optional_depends,omitempty"`
+	Permissions     []FilePermission `yaml:",omitempty"`
+}
+
+// FilePermission declares owner, group and octal mode a packaged file is installed with.
+// Owner and group are names or numeric ids.
+type FilePermission struct {
+	Path  string
+	Owner string `yaml:",omitempty"`
+	Group string `yaml:",omitempty"`
+	Mode  string `yaml:",omitempty"`
+}
+
+// Source is a file fetched before build. It can be written in META section either as plain url
+// or as mapping with url and any of sha256, sha512 and b2 digests.
+type Source struct {
+	Url    string
+	Sha256 string `yaml:",omitempty"`
+	Sha512 string `yaml:",omitempty"`
+	B2     string `yaml:",omitempty"`
+}
+
+// InstalledPackage is a record of package database describing installed package.
+type InstalledPackage struct {
+	Name            string       `json:"name"`
+	Version         string       `json:"version"`
+	Description     string       `json:"description,omitempty"`
+	Depends         []string     `json:"depends,omitempty"`
+	OptionalDepends []string     `json:"optional_depends,omitempty"`
+	InstallTime     time.Time    `json:"install_time"`
+	Reason          string       `json:"reason"`
+	Files           []FileRecord `json:"files"`
+	Definition      string       `json:"definition"`
+}
+
+// FileRecord describes file installed by package as it was stored in package archive.
+type FileRecord struct {
+	Path   string `json:"path" yaml:"path"`
+	Type   string `json:"type,omitempty" yaml:"type,omitempty"`
+	Mode   int64  `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Uid    int    `json:"uid" yaml:"uid"`
+	Gid    int    `json:"gid" yaml:"gid"`
+	Size   int64  `json:"size,omitempty" yaml:"size,omitempty"`
+	Sha256 string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
 }
@@ -2,16 +2,24 @@ package gum
 
 import (
 	"os"
-	"path/filepath"
 )
 
-func Uninstall(packageName string, verbose bool) error {
+// Uninstall runs uninstall script of package and removes its files from system at root.
+func Uninstall(packageName, root string, verbose bool) error {
 	err := isElevated()
 	if err != nil {
 		return err
 	}
 
-	pkg, err := getPackageFromIndex(packageName)
+	db, err := openDatabase(root)
+	if err != nil {
+		return err
+	}
+	installed, err := db.get(packageName)
+	if err != nil {
+		return err
+	}
+	pkg, err := installed.definition()
 	if err != nil {
 		return err
 	}
@@ -20,26 +28,41 @@ func Uninstall(packageName string, verbose bool) error {
 		return err
 	}
 	if pkg.UninstallLogic != "" {
-		if err := runScriptInDir(DefaultTempDir, pkg.UninstallLogic, verbose); err != nil {
+		workDir, err := makeWorkDir(currentConfig().TempDir, pkg.Name)
+		if err != nil {
+			return err
+		}
+		err = runInstallScript(root, workDir, pkg.UninstallLogic, scriptOutput{pkg: pkg.Name, stage: StageUninstall, verbose: verbose})
+		os.RemoveAll(workDir)
+		if err != nil {
 			return err
 		}
 	}
-	if err := removeRegularPackageFiles(pkg.Files); err != nil {
-		return err
+	// files taken over by another package, e.g. with --overwrite, stay for it
+	files := make([]string, 0, len(installed.Files))
+	for _, file := range installed.paths() {
+		if !ownedByOthers(db, file, installed.Name) {
+			files = append(files, file)
+		}
 	}
-	if err := removePackageDirectoriesIfEmpty(pkg.Files); err != nil {
+	if err := removeRegularPackageFiles(files, root); err != nil {
 		return err
 	}
-	if err := removePackageFromIndex(pkg.Name); err != nil {
+	if err := removePackageDirectoriesIfEmpty(installed.paths(), root); err != nil {
 		return err
 	}
-	return nil
+	db.remove(installed.Name)
+	return db.save()
 }
 
-func removePackageDirectoriesIfEmpty(dirs []string) error {
-	for _, dir := range dirs {
-		path := filepath.Join(RootDir, dir)
-		info, err := os.Stat(path)
+// removePackageDirectoriesIfEmpty removes empty directories among package files, deepest first.
+func removePackageDirectoriesIfEmpty(dirs []string, root string) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, err := resolveInRoot(root, dirs[i])
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
 		if os.IsNotExist(err) {
 			continue
 		}
@@ -66,10 +89,15 @@ func removePackageDirectoriesIfEmpty(dirs []string) error {
 	return nil
 }
 
-func removeRegularPackageFiles(files []string) error {
+// removeRegularPackageFiles removes every package file that is not a directory, symlinks are removed
+// themselves rather than their targets.
+func removeRegularPackageFiles(files []string, root string) error {
 	for _, file := range files {
-		path := filepath.Join(RootDir, file)
-		info, err := os.Stat(path)
+		path, err := resolveInRoot(root, file)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
 		if os.IsNotExist(err) {
 			continue
 		}
@@ -0,0 +1,142 @@
+package gum
+
+import (
+	"fmt"
+	"os"
+)
+
+// UpgradeOptions controls upgrade of installed package.
+type UpgradeOptions struct {
+	Verbose       bool
+	AllowUnsigned bool
+	Overwrite     []string
+	// Force allows replacing package with older version
+	Force bool
+}
+
+// Upgrade replaces installed package with version from archive without uninstalling it first.
+// Files dropped by the new version are removed. Downgrades are refused unless forced.
+func Upgrade(archivePath, root string, options UpgradeOptions) error {
+	err := isElevated()
+	if err != nil {
+		return err
+	}
+
+	pkg, packageDir, err := unpackPackage(archivePath, options.AllowUnsigned)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(packageDir)
+	db, err := openDatabase(root)
+	if err != nil {
+		return err
+	}
+	installed, err := db.get(pkg.Name)
+	if err != nil {
+		return err
+	}
+	if err := checkDowngrade(installed, pkg, options.Force); err != nil {
+		return err
+	}
+	if err := checkDependencies(db, pkg); err != nil {
+		return err
+	}
+	if err := ValidateInstalledDefinition(pkg); err != nil {
+		return err
+	}
+	if err := checkFileConflicts(db, root, pkg, packageDir, options.Overwrite); err != nil {
+		return err
+	}
+
+	tx := newTransaction()
+	if err := upgradePackage(tx, db, installed, pkg, packageDir, root, options.Verbose); err != nil {
+		return tx.abort(err)
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	if err := removePackageDirectoriesIfEmpty(droppedFiles(installed, pkg), root); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(packageDir)
+}
+
+// upgradePackage runs upgrade scripts, extracts new package files over the old ones, removes files
+// no longer shipped and replaces package record in the database. Install reason of package is kept.
+func upgradePackage(tx *transaction, db *packageDatabase, installed *InstalledPackage, pkg *PackageDefinition, packageDir, root string, verbose bool) error {
+	if pkg.BeforeUpgradeLogic != "" {
+		if err := runInstallScript(root, packageDir, pkg.BeforeUpgradeLogic, scriptOutput{pkg: pkg.Name, stage: StageBeforeUpgrade, verbose: verbose}); err != nil {
+			return err
+		}
+	}
+	if err := extractFilesToDir(packageDir, root, tx); err != nil {
+		return err
+	}
+	for _, file := range droppedFiles(installed, pkg) {
+		if ownedByOthers(db, file, pkg.Name) {
+			continue
+		}
+		path, err := resolveInRoot(root, file)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		if err := tx.remove(path); err != nil {
+			return err
+		}
+	}
+	upgraded, err := readInstalledPackage(pkg, packageDir, root, installed.Reason)
+	if err != nil {
+		return err
+	}
+	db.put(upgraded)
+	if err := db.stage(tx); err != nil {
+		return err
+	}
+	if pkg.AfterUpgradeLogic != "" {
+		if err := runInstallScript(root, packageDir, pkg.AfterUpgradeLogic, scriptOutput{pkg: pkg.Name, stage: StageAfterUpgrade, verbose: verbose}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkDowngrade(installed *InstalledPackage, pkg *PackageDefinition, force bool) error {
+	result, err := CompareVersions(pkg.Version, installed.Version)
+	if err != nil {
+		return err
+	}
+	if result < 0 && !force {
+		return fmt.Errorf("refusing to downgrade %s from %s to %s, use --force to allow it", pkg.Name, installed.Version, pkg.Version)
+	}
+
+	return nil
+}
+
+// droppedFiles returns files of installed package that are not part of its new version.
+func droppedFiles(installed *InstalledPackage, pkg *PackageDefinition) []string {
+	kept := make(map[string]bool, len(pkg.Files))
+	for _, file := range pkg.Files {
+		kept[normalizePackagePath(file)] = true
+	}
+
+	dropped := make([]string, 0)
+	for _, file := range installed.paths() {
+		if !kept[normalizePackagePath(file)] {
+			dropped = append(dropped, file)
+		}
+	}
+
+	return dropped
+}
@@ -0,0 +1,160 @@
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	DriftMissing     = "missing"
+	DriftModified    = "modified"
+	DriftPermissions = "permissions changed"
+	DriftType        = "type changed"
+)
+
+// FileDrift describes installed file that no longer matches the file installed by package.
+type FileDrift struct {
+	Package string
+	Path    string
+	Kind    string
+	Detail  string
+}
+
+func (d FileDrift) String() string {
+	if d.Detail == "" {
+		return fmt.Sprintf("%s: %s: %s", d.Package, d.Path, d.Kind)
+	}
+
+	return fmt.Sprintf("%s: %s: %s (%s)", d.Package, d.Path, d.Kind, d.Detail)
+}
+
+// Verify compares files of installed packages of system installed in root with their records in
+// package database and returns every difference found. Every installed package is verified when no names
+// are given. Checksums are compared only for files of packages built with them recorded.
+func Verify(packageNames []string, root string) ([]FileDrift, error) {
+	db, err := openDatabase(root)
+	if err != nil {
+		return nil, err
+	}
+
+	packages := db.list()
+	if len(packageNames) > 0 {
+		packages = make([]*InstalledPackage, 0, len(packageNames))
+		for _, name := range packageNames {
+			pkg, err := db.get(name)
+			if err != nil {
+				return nil, err
+			}
+			packages = append(packages, pkg)
+		}
+	}
+
+	drifts := make([]FileDrift, 0)
+	for _, pkg := range packages {
+		for _, record := range pkg.Files {
+			kind, detail, err := verifyFile(root, record)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", pkg.Name, err)
+			}
+			if kind != "" {
+				drifts = append(drifts, FileDrift{Package: pkg.Name, Path: record.Path, Kind: kind, Detail: detail})
+			}
+		}
+	}
+
+	return drifts, nil
+}
+
+// verifyFile checks single installed file, returning kind of difference and its description
+// or empty kind when file matches record.
+func verifyFile(root string, record FileRecord) (string, string, error) {
+	path, err := resolveInRoot(root, record.Path)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return DriftMissing, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	expectedType := record.Type
+	if expectedType == fileTypeHardLink {
+		expectedType = fileTypeRegular
+	}
+	if actualType := fileType(info.Mode()); expectedType != "" && actualType != expectedType {
+		return DriftType, fmt.Sprintf("expected %s, found %s", expectedType, actualType), nil
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", "", err
+		}
+		if target != record.Target {
+			return DriftModified, fmt.Sprintf("expected link to %s, found link to %s", record.Target, target), nil
+		}
+		return "", "", nil
+	}
+
+	if mode := unixPermissions(info.Mode()); mode != record.Mode {
+		return DriftPermissions, fmt.Sprintf("expected mode %04o, found %04o", record.Mode, mode), nil
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && (int(stat.Uid) != record.Uid || int(stat.Gid) != record.Gid) {
+		return DriftPermissions, fmt.Sprintf("expected owner %d:%d, found %d:%d", record.Uid, record.Gid, stat.Uid, stat.Gid), nil
+	}
+
+	if !info.Mode().IsRegular() || record.Sha256 == "" {
+		return "", "", nil
+	}
+	if info.Size() != record.Size {
+		return DriftModified, fmt.Sprintf("expected size %d, found %d", record.Size, info.Size()), nil
+	}
+	checksum, err := fileDigest(path, sha256Algorithm)
+	if err != nil {
+		return "", "", err
+	}
+	if checksum != record.Sha256 {
+		return DriftModified, "checksum mismatch", nil
+	}
+
+	return "", "", nil
+}
+
+// fileType returns file type name used in file records.
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return fileTypeDirectory
+	case mode&os.ModeSymlink != 0:
+		return fileTypeSymlink
+	case mode&os.ModeNamedPipe != 0:
+		return fileTypeFifo
+	case mode&os.ModeCharDevice != 0:
+		return fileTypeChar
+	case mode&os.ModeDevice != 0:
+		return fileTypeBlock
+	}
+
+	return fileTypeRegular
+}
+
+// unixPermissions converts permission, setuid, setgid and sticky bits of mode to their unix values.
+func unixPermissions(mode os.FileMode) int64 {
+	permissions := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		permissions |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		permissions |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		permissions |= 01000
+	}
+
+	return permissions
+}
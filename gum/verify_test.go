@@ -0,0 +1,100 @@
+package gum
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyFileHardLink(t *testing.T) {
+	root := t.TempDir()
+	content := []byte("hard linked content\n")
+	if err := os.MkdirAll(filepath.Join(root, "usr/bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(root, "usr/bin/a")
+	if err := os.WriteFile(target, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(target, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(target, filepath.Join(root, "usr/bin/b")); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(content)
+	checksum := hex.EncodeToString(digest[:])
+	size := int64(len(content))
+	pkg := &PackageDefinition{
+		Name: "links",
+		FileEntries: []PackageFile{
+			{Path: "usr/bin/a", Mode: 0644, Size: size, Sha256: checksum},
+			{Path: "usr/bin/b", Mode: 0644, Size: size, Sha256: checksum},
+		},
+	}
+	headers := []*tar.Header{
+		{Name: "usr/bin/a", Typeflag: tar.TypeReg, Mode: 0644, Size: size, Uid: os.Getuid(), Gid: os.Getgid()},
+		{Name: "usr/bin/b", Typeflag: tar.TypeLink, Linkname: "usr/bin/a", Mode: 0644, Uid: os.Getuid(), Gid: os.Getgid()},
+	}
+	installed := newInstalledPackage(pkg, "", headers, InstallReasonExplicit, nil)
+
+	link := installed.Files[1]
+	if link.Type != fileTypeHardLink || link.Size != size {
+		t.Fatalf("hard link recorded as %s of size %d, expected %s of size %d", link.Type, link.Size, fileTypeHardLink, size)
+	}
+	for _, record := range installed.Files {
+		kind, detail, err := verifyFile(root, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != "" {
+			t.Errorf("%s: unexpected %s drift: %s", record.Path, kind, detail)
+		}
+	}
+
+	if err := os.WriteFile(target, []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _, err := verifyFile(root, installed.Files[1]); err != nil || kind != DriftModified {
+		t.Errorf("modified hard link: expected %s drift, got %q (%v)", DriftModified, kind, err)
+	}
+}
+
+func TestVerifyExistingDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "usr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "usr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// package built with umask 002 ships group writable directory, the existing one is kept as it is
+	pkg := &PackageDefinition{Name: "umask"}
+	headers := []*tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0775, Uid: os.Getuid() + 1, Gid: os.Getgid() + 1},
+	}
+	installed := newInstalledPackage(pkg, "", headers, InstallReasonExplicit, nil)
+	if err := installed.recordDirectories(root); err != nil {
+		t.Fatal(err)
+	}
+
+	record := installed.Files[0]
+	if record.Mode != 0755 || record.Uid != os.Getuid() || record.Gid != os.Getgid() {
+		t.Errorf("directory recorded as %04o %d:%d", record.Mode, record.Uid, record.Gid)
+	}
+	if kind, detail, err := verifyFile(root, record); err != nil || kind != "" {
+		t.Errorf("existing directory: %s %s %v", kind, detail, err)
+	}
+
+	if err := os.Chmod(filepath.Join(root, "usr"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _, err := verifyFile(root, record); err != nil || kind != DriftPermissions {
+		t.Errorf("changed directory: expected %s drift, got %q (%v)", DriftPermissions, kind, err)
+	}
+}
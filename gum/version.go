@@ -0,0 +1,226 @@
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	epochSeparator   = ":"
+	releaseSeparator = "-"
+)
+
+// preReleaseRanks orders well known pre-release tags. Any other letters (like in openssl 1.1.1w)
+// mark a post-release and rank above all of them.
+var preReleaseRanks = map[string]int{
+	"alpha": 1,
+	"beta":  2,
+	"pre":   3,
+	"rc":    4,
+}
+
+const postReleaseRank = 5
+
+// Version is a parsed package version in the form [epoch:]upstream[-release].
+type Version struct {
+	Epoch    int
+	Upstream string
+	Release  string
+}
+
+// ParseVersion splits version string into epoch, upstream version and release number.
+func ParseVersion(version string) (Version, error) {
+	v := Version{}
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return v, errors.New("empty version")
+	}
+
+	if i := strings.Index(version, epochSeparator); i >= 0 {
+		epoch, err := strconv.Atoi(version[:i])
+		if err != nil || epoch < 0 {
+			return v, fmt.Errorf("invalid epoch in version %q", version)
+		}
+		v.Epoch = epoch
+		version = version[i+1:]
+	}
+
+	if i := strings.LastIndex(version, releaseSeparator); i > 0 && isNumeric(version[i+1:]) {
+		v.Release = version[i+1:]
+		version = version[:i]
+	}
+
+	if version == "" {
+		return v, errors.New("empty upstream version")
+	}
+	v.Upstream = version
+
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := v.Upstream
+	if v.Epoch != 0 {
+		s = strconv.Itoa(v.Epoch) + epochSeparator + s
+	}
+	if v.Release != "" {
+		s = s + releaseSeparator + v.Release
+	}
+
+	return s
+}
+
+// Compare returns -1 if v is older than other, 1 if it is newer and 0 if both are equal.
+// Release numbers are compared only when both versions have one.
+func (v Version) Compare(other Version) int {
+	if v.Epoch != other.Epoch {
+		return compareInts(v.Epoch, other.Epoch)
+	}
+	if c := compareSegments(v.Upstream, other.Upstream); c != 0 {
+		return c
+	}
+	if v.Release != "" && other.Release != "" {
+		return compareSegments(v.Release, other.Release)
+	}
+
+	return 0
+}
+
+// CompareVersions parses and compares two version strings.
+func CompareVersions(a, b string) (int, error) {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return va.Compare(vb), nil
+}
+
+// compareSegments compares versions component by component. Numeric components compare numerically,
+// pre-release tags sort before the release they precede, so 1.0rc1 < 1.0 < 1.0a < 1.0.1.
+func compareSegments(a, b string) int {
+	ca := splitVersionComponents(a)
+	cb := splitVersionComponents(b)
+
+	for i := 0; i < len(ca) && i < len(cb); i++ {
+		if c := compareComponents(ca[i], cb[i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(ca) > len(cb):
+		if isPreRelease(ca[len(cb)]) {
+			return -1
+		}
+		return 1
+	case len(ca) < len(cb):
+		if isPreRelease(cb[len(ca)]) {
+			return 1
+		}
+		return -1
+	}
+
+	return 0
+}
+
+func compareComponents(a, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return compareInts(len(a), len(b))
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return 1
+	case bNumeric:
+		return -1
+	}
+
+	if rankA, rankB := releaseRank(a), releaseRank(b); rankA != rankB {
+		return compareInts(rankA, rankB)
+	}
+
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func releaseRank(component string) int {
+	if rank, ok := preReleaseRanks[strings.ToLower(component)]; ok {
+		return rank
+	}
+
+	return postReleaseRank
+}
+
+func isPreRelease(component string) bool {
+	return !isNumeric(component) && releaseRank(component) != postReleaseRank
+}
+
+// splitVersionComponents splits version into runs of digits and letters, dropping separators.
+func splitVersionComponents(version string) []string {
+	components := make([]string, 0)
+	current := strings.Builder{}
+	currentIsDigit := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			components = append(components, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range version {
+		switch {
+		case unicode.IsDigit(r):
+			if !currentIsDigit {
+				flush()
+			}
+			currentIsDigit = true
+			current.WriteRune(r)
+		case unicode.IsLetter(r):
+			if currentIsDigit {
+				flush()
+			}
+			currentIsDigit = false
+			current.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return components
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
@@ -1,14 +1,38 @@
 package main
 
-import "github.com/hellflame/argparse"
+import (
+	"github.com/adamjedrzejewski/gumshield/gum"
+	"github.com/hellflame/argparse"
+	"log"
+)
 
 func main() {
+	if gum.IsSandboxChild() {
+		gum.RunSandboxChild()
+	}
+
+	config, err := gum.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	parser := argparse.NewParser("gumshield", "gumshield package manager", nil)
+	root := parser.String("r", "root", &argparse.Option{
+		Help:        "alternate root directory of managed system",
+		Default:     gum.RootDir,
+		Inheritable: true})
 
-	registerBuildCommand(parser)
-	registerInstallCommand(parser)
-	registerShowCommand(parser)
-	registerUninstallCommand(parser)
+	registerBuildCommand(parser, config)
+	registerBuildAllCommand(parser, config)
+	registerKeygenCommand(parser, config)
+	registerInstallCommand(parser, root)
+	registerUpgradeCommand(parser, root)
+	registerVerifyArchiveCommand(parser)
+	registerRepoAddCommand(parser)
+	registerOwnsCommand(parser, root)
+	registerVerifyCommand(parser, root)
+	registerShowCommand(parser, root)
+	registerUninstallCommand(parser, root)
 
 	_ = parser.Parse(nil)
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/adamjedrzejewski/gumshield/gum"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+func outputChoices() []interface{} {
+	return []interface{}{outputText, outputJSON, outputYAML}
+}
+
+// render writes value to standard output as JSON or YAML, text output is written by printText.
+func render(format string, value interface{}, printText func()) {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			log.Fatal(err)
+		}
+	case outputYAML:
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(value); err != nil {
+			log.Fatal(err)
+		}
+		if err := encoder.Close(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		printText()
+	}
+}
+
+// printFileRecord prints file record in ls -l like format.
+func printFileRecord(record gum.FileRecord) {
+	line := fmt.Sprintf("%-9s %04o %5d/%-5d %10d %s", record.Type, record.Mode, record.Uid, record.Gid, record.Size, record.Path)
+	if record.Target != "" {
+		line += " -> " + record.Target
+	}
+	fmt.Println(line)
+}
+
+// printScript prints script of package under heading.
+func printScript(heading, script string) {
+	fmt.Println(heading + ":")
+	fmt.Println(script)
+}